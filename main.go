@@ -22,12 +22,12 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/zmap/zcrypto/tls"
-	"github.com/zmap/zcrypto/x509"
 	"github.com/zmap/zgrab/zlib"
 	"github.com/zmap/zgrab/ztools/processing"
 	"github.com/zmap/zgrab/ztools/zlog"
@@ -43,10 +43,27 @@ var (
 	portFlag                      uint
 	inputFile, metadataFile       *os.File
 	timeout                       uint
+	retryBackoff                  uint
+	tcpKeepAlive                  int
+	tcpLinger                     int
 	tlsVersion                    string
 	rootCAFileName                string
 	prometheusAddress             string
 	clientHelloFileName           string
+	keyLogFileName                string
+	mqttProtocolLevel             uint
+	multipleSNI                   string
+	maxFragmentLength             uint
+	clientHelloRecordVersion      uint
+	httpPipeline                  string
+	httpWellKnownPaths            string
+	proactiveBannerTimeout        uint
+	forceCipher                   uint
+	tlsCurves                     string
+	writeFragmentDelay            uint
+	clientHelloMalformation       string
+	parseCertLimit                int
+	smtpGreetingWait              uint
 )
 
 // Module configurations
@@ -74,33 +91,85 @@ func init() {
 	flag.BoolVar(&config.TLS, "tls", false, "Grab over TLS")
 	flag.StringVar(&tlsVersion, "tls-version", "", "Max TLS version to use (implies --tls)")
 	flag.BoolVar(&config.TLSCertsOnly, "tls-certs-only", false, "End TLS connection after receiving server certificates (implies --tls)")
+	flag.UintVar(&maxFragmentLength, "tls-max-fragment-length", 0, "Offer the TLS max_fragment_length extension with this code (1-4 for 512-4096 bytes)")
+	flag.UintVar(&clientHelloRecordVersion, "tls-record-version", 0, "Record layer version to send the ClientHello with, e.g. 0x0300 or 0x0303 (0 for the 0x0301 default)")
+	flag.UintVar(&forceCipher, "tls-force-cipher", 0, "Offer only this cipher suite, e.g. 0x0033 for a DHE handshake (overrides the cipher list options)")
+	flag.StringVar(&tlsCurves, "tls-curves", "", "Comma-separated curve IDs to offer in supported_groups, e.g. 29 for X25519 or 23 for P-256; use with --tls-force-cipher to test a single curve")
+	flag.BoolVar(&config.TLSFallbackSCSV, "tls-fallback-scsv", false, "Offer TLS_FALLBACK_SCSV; use with --tls-version below the server's max to test downgrade protection")
+	flag.BoolVar(&config.TLSVersionIntolerance, "tls-version-intolerance", false, "Probe whether the server fails on higher or unknown ClientHello versions")
+	flag.BoolVar(&config.TLSCipherPreference, "tls-cipher-preference", false, "Probe whether the server enforces its own cipher suite order")
+	flag.BoolVar(&config.TLSNested, "tls-nested", false, "After the TLS handshake, make a second one inside the tunnel, e.g. to a TLS origin behind stunnel (requires --tls)")
+	flag.BoolVar(&config.TLSFlat, "tls-flat", false, "Output the TLS handshake as a flat, fixed set of fields under tls_flat instead of tls, for loading into BigQuery")
+	flag.BoolVar(&config.TLSProfile, "tls-profile", false, "Enumerate the versions and cipher suites the server accepts, its cipher preference and key exchange parameters")
+	flag.IntVar(&config.TLSProfileMaxHandshakes, "tls-profile-max-handshakes", 100, "Maximum handshakes made by --tls-profile")
+	flag.BoolVar(&config.TLSPaddingProbe, "tls-padding-probe", false, "Probe whether adding the padding extension changes whether the server answers the ClientHello")
+	flag.IntVar(&config.TLSTicketKeyReuse, "tls-ticket-key-reuse", 0, "Collect this many session tickets over separate connections and check whether the ticket key is shared (0 to disable)")
+	flag.IntVar(&config.TLSMaxCertChainLength, "tls-max-chain-length", 16, "Max number of server certificates to parse and record, negative for no limit")
+	flag.IntVar(&parseCertLimit, "tls-parse-cert-limit", -1, "Parse only this many server certificates, recording the rest raw, negative for no limit (a partly parsed chain is not validated)")
 	flag.UintVar(&config.Senders, "senders", 1000, "Number of send coroutines to use")
 	flag.UintVar(&config.ConnectionsPerHost, "connections-per-host", 1, "Number of times to connect to each host (results in more output)")
+	flag.IntVar(&tcpKeepAlive, "tcp-keepalive", 0, "TCP keepalive period in seconds (0 for the default, -1 to disable keepalives)")
+	flag.IntVar(&tcpLinger, "tcp-linger", -1, "SO_LINGER timeout in seconds; 0 resets connections on close to avoid TIME_WAIT (-1 for the OS default)")
+	flag.BoolVar(&config.ConnectOnly, "connect-only", false, "Only make the TCP connection and record whether it succeeded and how long it took")
+	flag.BoolVar(&config.AutoProbe, "auto-probe", false, "Guess the service from its banner, or lack of one, and probe it accordingly")
+	flag.UintVar(&proactiveBannerTimeout, "proactive-banner-timeout", 0, "Wait this many milliseconds for a banner before writing anything, without failing if none arrives (0 to not wait)")
+	flag.IntVar(&config.WriteFragmentSize, "write-fragment-size", 0, "Split every write, including the TLS ClientHello, into pieces of this many bytes (0 to disable)")
+	flag.UintVar(&writeFragmentDelay, "write-fragment-delay", 0, "Wait this many milliseconds between write fragments (requires --write-fragment-size)")
+	flag.IntVar(&config.RawBannerSize, "raw-banner-size", 0, "Record the first this many bytes received, before TLS or protocol parsing (0 to disable)")
+	flag.UintVar(&config.MaxAttempts, "max-attempts", 1, "Maximum attempts per host, retrying after transient errors such as connection resets and timeouts")
+	flag.UintVar(&retryBackoff, "retry-backoff", 500, "Milliseconds to wait before the first retry, doubling for each further retry up to a minute")
 	flag.BoolVar(&config.Banners, "banners", false, "Read banner upon connection creation")
+	flag.IntVar(&config.BannerLines, "banner-lines", 0, "Read up to this many CRLF-terminated lines as the banner (implies --banners)")
 	flag.StringVar(&messageFileName, "data", "", "Send a message and read response (%s will be replaced with destination IP)")
+	flag.BoolVar(&config.HalfClose, "half-close", false, "Half-close the connection after sending --data and record how the server reacts (requires --data)")
 	flag.StringVar(&config.HTTP.Endpoint, "http", "", "Send an HTTP request to an endpoint")
 	flag.StringVar(&config.HTTP.Method, "http-method", "GET", "Set HTTP request method type")
 	flag.StringVar(&config.HTTP.UserAgent, "http-user-agent", "Mozilla/5.0 zgrab/0.x", "Set a custom HTTP user agent")
+	flag.StringVar(&config.HTTP.AcceptEncoding, "http-accept-encoding", "identity", "Accept-Encoding to send; empty lets the client request and transparently decode gzip")
 	flag.StringVar(&config.HTTP.ProxyDomain, "http-proxy-domain", "", "Send a CONNECT <domain> first")
+	flag.StringVar(&httpPipeline, "http-pipeline", "", "Comma-separated list of endpoints to request pipelined over one connection, e.g. /robots.txt,/")
+	flag.BoolVar(&config.HTTPWellKnown, "http-well-known", false, "Request well-known paths such as /robots.txt and /.git/HEAD over one connection and flag notable responses")
+	flag.StringVar(&httpWellKnownPaths, "http-well-known-paths", "", "Comma-separated list of paths to use with --http-well-known instead of the defaults")
+	flag.StringVar(&config.HTTP.ProtocolVersion, "http-protocol-version", "HTTP/1.1", "HTTP version to send requests with, HTTP/1.1 or HTTP/1.0")
 	flag.IntVar(&config.HTTP.MaxSize, "http-max-size", 256, "Max kilobytes to read in response to an HTTP request")
 	flag.IntVar(&config.HTTP.MaxRedirects, "http-max-redirects", 0, "Max number of redirects to follow")
+	flag.DurationVar(&config.HTTP.MaxTotalTime, "http-max-total-time", 0, "Max time to spend on an HTTP grab including all redirects, 0 for no limit")
+	flag.StringVar(&config.HTTP.BasicAuthUser, "http-basic-auth-user", "", "Username to send with HTTP Basic authentication")
+	flag.StringVar(&config.HTTP.BasicAuthPassword, "http-basic-auth-password", "", "Password to send with HTTP Basic authentication")
+	flag.StringVar(&config.HTTP.BearerToken, "http-bearer-token", "", "Token to send with HTTP Bearer authentication")
+	flag.BoolVar(&config.HTTP.RedactAuth, "http-redact-auth", true, "Redact the Authorization header in recorded requests")
+	flag.BoolVar(&config.HTTP.RawHeaders, "http-raw-headers", false, "Record the HTTP status line and headers exactly as received")
+	flag.BoolVar(&config.HTTP.PreserveHeaderCase, "http-preserve-header-case", false, "Also record the HTTP headers in the order and casing received")
+	flag.BoolVar(&config.HTTP.ProbeDefaultVhost, "http-probe-default-vhost", false, "Also request the endpoint with a nonexistent Host header to capture the default virtual host")
+	flag.BoolVar(&config.HTTP.DecodeCharset, "http-decode-charset", false, "Also record the HTTP body transcoded to UTF-8 from the charset declared in Content-Type or a <meta> tag (ISO-8859-1, windows-1252 or Shift_JIS; others are recorded as unsupported)")
 	flag.BoolVar(&config.HTTP.FollowLocalhostRedirects, "follow-localhost-redirects", true, "Follow HTTP redirects to localhost")
 	flag.BoolVar(&config.TLSExtendedRandom, "tls-extended-random", false, "send extended random extension")
 	flag.BoolVar(&config.SignedCertificateTimestampExt, "signed-certificate-timestamp", true, "request SCTs during TLS handshake")
 
 	flag.StringVar(&config.EHLODomain, "ehlo", "", "Send an EHLO with the specified domain (implies --smtp)")
 	flag.BoolVar(&config.SMTPHelp, "smtp-help", false, "Send a SMTP help (implies --smtp)")
+	flag.StringVar(&config.SMTPVrfy, "smtp-vrfy", "", "Send a SMTP VRFY for the specified user (implies --smtp)")
+	flag.StringVar(&config.SMTPExpn, "smtp-expn", "", "Send a SMTP EXPN for the specified list (implies --smtp)")
+	flag.BoolVar(&config.IMAPID, "imap-id", false, "Send an IMAP ID command (implies --imap)")
+	flag.BoolVar(&config.IMAPCapability, "imap-capability", false, "Send an IMAP CAPABILITY command, and again after STARTTLS, recording any changes (implies --imap)")
 	flag.BoolVar(&config.StartTLS, "starttls", false, "Send STARTTLS before negotiating")
+	flag.BoolVar(&config.SMTPStartTLSInjection, "smtp-starttls-injection", false, "Send a command along with STARTTLS and check whether it is answered after the handshake (implies --smtp and --starttls)")
+	flag.UintVar(&smtpGreetingWait, "smtp-greeting-wait", 0, "Stop waiting for the SMTP greeting after this many milliseconds, keeping what arrived (0 to wait for the full timeout; requires --smtp)")
+	flag.BoolVar(&config.SMTPPipelining, "smtp-pipelining", false, "Send EHLO and STARTTLS in one write, for servers an earlier grab saw advertise PIPELINING (implies --smtp and --starttls)")
 	flag.BoolVar(&config.SMTP, "smtp", false, "Conform to SMTP when reading responses and sending STARTTLS")
 	flag.BoolVar(&config.IMAP, "imap", false, "Conform to IMAP rules when sending STARTTLS")
 	flag.BoolVar(&config.POP3, "pop3", false, "Conform to POP3 rules when sending STARTTLS")
+	flag.BoolVar(&config.ImplicitTLS, "implicit-tls", false, "Negotiate TLS before reading the SMTP, IMAP or POP3 banner (implies --tls)")
 	flag.BoolVar(&config.Modbus, "modbus", false, "Send some modbus data")
 	flag.BoolVar(&config.BACNet, "bacnet", false, "Send some BACNet data")
 	flag.BoolVar(&config.Fox, "fox", false, "Send some Niagara Fox Tunneling data")
 	flag.BoolVar(&config.S7, "s7", false, "Send some Siemens S7 data")
 	flag.BoolVar(&config.NoSNI, "no-sni", false, "Do not send domain name in TLS handshake regardless of whether known")
+	flag.BoolVar(&config.EmptySNI, "empty-sni", false, "Send a server_name extension with a zero-length name in TLS handshake")
+	flag.StringVar(&multipleSNI, "multiple-sni", "", "Comma-separated list of names to send together in the TLS server_name extension (malformed ClientHello)")
 
 	flag.StringVar(&clientHelloFileName, "raw-client-hello", "", "Provide a raw ClientHello to be sent; only the SNI will be rewritten")
+	flag.StringVar(&keyLogFileName, "tls-keylog-file", "", "DANGEROUS, debugging only: append TLS master secrets to this file in NSS key log format")
 
 	flag.BoolVar(&config.ExportsOnly, "export-ciphers", false, "Send only export ciphers")
 	flag.BoolVar(&config.ExportsDHOnly, "export-dhe-ciphers", false, "Send only export DHE ciphers")
@@ -118,16 +187,33 @@ func init() {
 	flag.BoolVar(&config.Heartbleed, "heartbleed", false, "Check if server is vulnerable to Heartbleed (implies --tls)")
 
 	flag.BoolVar(&config.GatherSessionTicket, "tls-session-ticket", false, "Send support for TLS Session Tickets and output ticket if presented")
-	flag.BoolVar(&config.ExtendedMasterSecret, "tls-extended-master-secret", false, "Offer RFC 7627 Extended Master Secret extension")
+	flag.BoolVar(&config.ExtendedMasterSecret, "tls-extended-master-secret", true, "Offer RFC 7627 Extended Master Secret extension")
+	flag.BoolVar(&config.TLSALPS, "tls-alps", false, "Offer ALPN and the ALPS extension as Chrome does (requires --chrome-ciphers or --chrome-no-dhe-ciphers; not used for HTTP)")
+	flag.BoolVar(&config.TLSClientHelloPadding, "tls-padding", false, "Pad ClientHellos of 256 to 511 bytes to 512 with the RFC 7685 padding extension, for F5 appliances that drop them")
+	flag.BoolVar(&config.TLSCertCompression, "tls-cert-compression", false, "Offer RFC 8879 zlib certificate compression and decompress the server's certificates if it is used")
+	flag.StringVar(&clientHelloMalformation, "tls-malformed-client-hello", "", "Corrupt the ClientHello extensions block and record the server's reaction: oversized_extensions, truncated_extensions or zero_extensions_length")
+	flag.BoolVar(&config.EncryptThenMAC, "tls-encrypt-then-mac", false, "Offer RFC 7366 Encrypt-then-MAC extension (probe only; CBC handshakes are abandoned with an error if the server accepts)")
 	flag.BoolVar(&config.TLSVerbose, "tls-verbose", false, "Add extra TLS information to JSON output (client hello, client KEX, key material, etc)")
 
 	flag.StringVar(&rootCAFileName, "ca-file", "", "List of trusted root certificate authorities in PEM format")
 	flag.IntVar(&config.GOMAXPROCS, "gomaxprocs", 3, "Set GOMAXPROCS (default 3)")
 	flag.BoolVar(&config.FTP, "ftp", false, "Read FTP banners")
 	flag.BoolVar(&config.FTPAuthTLS, "ftp-authtls", false, "Collect FTPS certificates in addition to FTP banners")
+	flag.BoolVar(&config.FTPProt, "ftp-prot", false, "After AUTH TLS, send PBSZ 0 and PROT P to check for protected data channels (requires --ftp-authtls)")
 	flag.BoolVar(&config.DNP3, "dnp3", false, "Read DNP3 banners")
 	flag.BoolVar(&config.Telnet, "telnet", false, "Read telnet banners")
 	flag.IntVar(&config.TelnetMaxSize, "telnet-max-size", 65536, "Max bytes to read for telnet banner")
+	flag.StringVar(&config.WhoisQuery, "whois", "", "Send the specified whois query and record the response")
+	flag.IntVar(&config.WhoisMaxSize, "whois-max-size", 65536, "Max bytes to read for a whois response")
+	flag.BoolVar(&config.MQTT, "mqtt", false, "Send an anonymous MQTT CONNECT and record the CONNACK")
+	flag.UintVar(&mqttProtocolLevel, "mqtt-protocol-level", 4, "MQTT protocol level to offer with --mqtt (4 for 3.1.1, 5 for 5.0)")
+	flag.BoolVar(&config.SIP, "sip", false, "Send a SIP OPTIONS request over TCP and record the response")
+	flag.BoolVar(&config.Finger, "finger", false, "Send a finger query and record the response")
+	flag.StringVar(&config.FingerQuery, "finger-query", "", "Query to send with --finger (empty lists logged-in users)")
+	flag.BoolVar(&config.Gopher, "gopher", false, "Send a gopher selector and record the response")
+	flag.StringVar(&config.GopherSelector, "gopher-selector", "", "Selector to send with --gopher (empty requests the root menu)")
+	flag.BoolVar(&config.IRC, "irc", false, "Register with an IRC server and record its identification and capabilities")
+	flag.StringVar(&config.IRCNick, "irc-nick", "zgrab", "Nickname to register with when using --irc")
 
 	// Flags for XSSH scanner
 	flag.BoolVar(&config.XSSH.XSSH, "xssh", false, "Use the x/crypto SSH scanner")
@@ -152,6 +238,24 @@ func init() {
 	if config.HTTP.Method != "GET" && config.HTTP.Method != "HEAD" {
 		zlog.Fatalf("Bad HTTP Method: %s. Valid options are: GET, HEAD.", config.HTTP.Method)
 	}
+	if config.HTTP.ProtocolVersion != "HTTP/1.1" && config.HTTP.ProtocolVersion != "HTTP/1.0" {
+		zlog.Fatalf("Bad HTTP protocol version: %s. Valid options are: HTTP/1.1, HTTP/1.0.", config.HTTP.ProtocolVersion)
+	}
+	if httpPipeline != "" {
+		if config.HTTP.Endpoint != "" {
+			zlog.Fatal("--http-pipeline and --http are mutually exclusive")
+		}
+		config.HTTPPipeline = strings.Split(httpPipeline, ",")
+	}
+	if config.HTTPWellKnown && config.HTTP.Endpoint != "" {
+		zlog.Fatal("--http-well-known and --http are mutually exclusive")
+	}
+	if httpWellKnownPaths != "" {
+		if !config.HTTPWellKnown {
+			zlog.Fatal("--http-well-known-paths requires --http-well-known")
+		}
+		config.HTTPWellKnownPaths = strings.Split(httpWellKnownPaths, ",")
+	}
 
 	// Validate FTP
 	if config.FTP && config.Banners {
@@ -160,12 +264,22 @@ func init() {
 	if config.FTPAuthTLS && !config.FTP {
 		zlog.Fatal("--ftp-authtls requires usage of --ftp")
 	}
+	if config.FTPProt && !config.FTPAuthTLS {
+		zlog.Fatal("--ftp-prot requires usage of --ftp-authtls")
+	}
 
 	// Validate Telnet
 	if config.Telnet && config.Banners {
 		zlog.Fatal("--telnet and --banners are mutually exclusive")
 	}
 
+	if config.ImplicitTLS {
+		if config.StartTLS {
+			zlog.Fatal("--implicit-tls and --starttls are mutually exclusive")
+		}
+		config.TLS = true
+	}
+
 	// Validate TLS Versions
 	tv := strings.ToUpper(tlsVersion)
 	if tv != "" {
@@ -192,6 +306,72 @@ func init() {
 		}
 	}
 
+	if config.TLSALPS && !(config.ChromeOnly || config.ChromeNoDHE) {
+		zlog.Fatal("--tls-alps requires --chrome-ciphers or --chrome-no-dhe-ciphers")
+	}
+
+	if config.EmptySNI && (config.NoSNI || multipleSNI != "") {
+		zlog.Fatal("--empty-sni cannot be used with --no-sni or --multiple-sni")
+	}
+
+	if maxFragmentLength > 4 {
+		zlog.Fatal("TLS max fragment length code", maxFragmentLength, "out of range")
+	}
+	config.TLSMaxFragmentLength = uint8(maxFragmentLength)
+
+	if clientHelloRecordVersion > 0xffff {
+		zlog.Fatalf("TLS record version %#x out of range", clientHelloRecordVersion)
+	}
+	config.TLSClientHelloRecordVersion = uint16(clientHelloRecordVersion)
+
+	if forceCipher > 0xffff {
+		zlog.Fatalf("Cipher suite %#x out of range", forceCipher)
+	}
+	config.TLSForceCipher = uint16(forceCipher)
+
+	if tlsCurves != "" {
+		for _, s := range strings.Split(tlsCurves, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 0, 16)
+			if err != nil {
+				zlog.Fatalf("Invalid curve ID %q in --tls-curves", s)
+			}
+			config.TLSCurves = append(config.TLSCurves, tls.CurveID(id))
+		}
+	}
+
+	if parseCertLimit >= 0 {
+		config.TLSLimitCertParsing = true
+		config.TLSParseCertLimit = parseCertLimit
+	}
+
+	if clientHelloMalformation != "" {
+		for _, kind := range tls.ClientHelloMalformations {
+			if string(kind) == clientHelloMalformation {
+				config.TLSClientHelloMalformation = kind
+			}
+		}
+		if config.TLSClientHelloMalformation == "" {
+			zlog.Fatalf("Unknown ClientHello malformation %s", clientHelloMalformation)
+		}
+	}
+
+	if multipleSNI != "" {
+		config.MultipleSNI = strings.Split(multipleSNI, ",")
+	}
+
+	if config.SMTPStartTLSInjection {
+		config.SMTP = true
+		config.StartTLS = true
+	}
+
+	if config.SMTPPipelining {
+		if config.SMTPHelp || config.SMTPVrfy != "" || config.SMTPExpn != "" || config.SMTPStartTLSInjection {
+			zlog.Fatal("--smtp-pipelining cannot be used with commands sent between EHLO and STARTTLS")
+		}
+		config.SMTP = true
+		config.StartTLS = true
+	}
+
 	// STARTTLS cannot be used with TLS
 	if config.StartTLS && config.TLS {
 		zlog.Fatal("Cannot both initiate a TLS and STARTTLS connection")
@@ -201,7 +381,7 @@ func init() {
 		config.EHLO = true
 	}
 
-	if config.SMTPHelp || config.EHLO {
+	if config.SMTPHelp || config.EHLO || config.SMTPVrfy != "" || config.SMTPExpn != "" {
 		config.SMTP = true
 	}
 
@@ -214,10 +394,23 @@ func init() {
 		config.EHLO = true
 	}
 
+	if config.BannerLines > 0 {
+		config.Banners = true
+	}
+
+	if config.IMAPID || config.IMAPCapability {
+		config.IMAP = true
+	}
+
 	if config.SMTP && (config.IMAP || config.POP3) {
 		zlog.Fatal("Cannot conform to SMTP and IMAP/POP3 at the same time")
 	}
 
+	config.SMTPGreetingWait = time.Duration(smtpGreetingWait) * time.Millisecond
+	if config.SMTPGreetingWait > 0 && !config.SMTP {
+		zlog.Fatal("--smtp-greeting-wait requires --smtp")
+	}
+
 	if config.IMAP && config.POP3 {
 		zlog.Fatal("Cannot conform to IMAP and POP3 at the same time")
 	}
@@ -239,6 +432,12 @@ func init() {
 		zlog.Fatal("Must specify one of --tls or --starttls for --heartbleed")
 	}
 
+	// Validate MQTT
+	if mqttProtocolLevel != zlib.MQTTProtocolLevel311 && mqttProtocolLevel != zlib.MQTTProtocolLevel5 {
+		zlog.Fatal("MQTT protocol level", mqttProtocolLevel, "not supported")
+	}
+	config.MQTTProtocolLevel = uint8(mqttProtocolLevel)
+
 	// Validate SMB
 	if config.SMB.SMB {
 		if config.SMB.Protocol != 1 {
@@ -255,6 +454,57 @@ func init() {
 	// Validate timeout
 	config.Timeout = time.Duration(timeout) * time.Second
 
+	// Validate socket options
+	config.TCPKeepAlive = time.Duration(tcpKeepAlive) * time.Second
+	if tcpLinger >= 0 {
+		config.TCPLinger = &tcpLinger
+	}
+
+	if config.ConnectOnly && (config.HTTP.Endpoint != "" || config.XSSH.XSSH || config.BACNet) {
+		zlog.Fatal("--connect-only cannot be used with --http, --xssh or --bacnet")
+	}
+
+	if config.AutoProbe && (config.TLS || config.Banners || config.HTTP.Endpoint != "" || config.ConnectOnly) {
+		zlog.Fatal("--auto-probe cannot be used with --tls, --banners, --http or --connect-only")
+	}
+
+	config.ProactiveBannerTimeout = time.Duration(proactiveBannerTimeout) * time.Millisecond
+	if config.ProactiveBannerTimeout > 0 && config.Banners {
+		zlog.Fatal("--proactive-banner-timeout and --banners are mutually exclusive")
+	}
+
+	if config.RawBannerSize < 0 || config.RawBannerSize > 65536 {
+		zlog.Fatalf("Invalid raw banner size (must be between 0 and 65536, given %d)", config.RawBannerSize)
+	}
+
+	if config.TLSTicketKeyReuse < 0 || config.TLSTicketKeyReuse > 32 {
+		zlog.Fatalf("Invalid ticket count (must be between 0 and 32, given %d)", config.TLSTicketKeyReuse)
+	}
+	if config.TLSNested && !config.TLS {
+		zlog.Fatal("--tls-nested requires --tls")
+	}
+	if config.TLSProfile && config.TLSProfileMaxHandshakes < 1 {
+		zlog.Fatalf("Invalid TLS profile handshake limit (must be at least 1, given %d)", config.TLSProfileMaxHandshakes)
+	}
+
+	if config.WhoisMaxSize < 1 {
+		zlog.Fatalf("Invalid whois max size (must be at least 1, given %d)", config.WhoisMaxSize)
+	}
+
+	if config.WriteFragmentSize < 0 || config.WriteFragmentSize > 65536 {
+		zlog.Fatalf("Invalid write fragment size (must be between 0 and 65536, given %d)", config.WriteFragmentSize)
+	}
+	config.WriteFragmentDelay = time.Duration(writeFragmentDelay) * time.Millisecond
+	if config.WriteFragmentDelay > 0 && config.WriteFragmentSize == 0 {
+		zlog.Fatal("--write-fragment-delay requires --write-fragment-size")
+	}
+
+	// Validate retries
+	if config.MaxAttempts < 1 || config.MaxAttempts > 10 {
+		zlog.Fatalf("Invalid max attempts (must be between 1 and 10, given %d)", config.MaxAttempts)
+	}
+	config.RetryBackoff = time.Duration(retryBackoff) * time.Millisecond
+
 	// Validate senders
 	if config.Senders == 0 {
 		zlog.Fatal("Error: Need at least one sender")
@@ -265,19 +515,9 @@ func init() {
 
 	// Look at CA file
 	if rootCAFileName != "" {
-		var fd *os.File
-		if fd, err = os.Open(rootCAFileName); err != nil {
+		if config.RootCAPool, err = zlib.NewCertPoolFromPEM(rootCAFileName); err != nil {
 			zlog.Fatal(err)
 		}
-		caBytes, readErr := ioutil.ReadAll(fd)
-		if readErr != nil {
-			zlog.Fatal(err)
-		}
-		config.RootCAPool = x509.NewCertPool()
-		ok := config.RootCAPool.AppendCertsFromPEM(caBytes)
-		if !ok {
-			zlog.Fatal("Could not read certificates from PEM file. Invalid PEM?")
-		}
 	}
 
 	// Open input and output files
@@ -299,6 +539,10 @@ func init() {
 		}
 	}
 
+	if config.HalfClose && messageFileName == "" {
+		zlog.Fatal("--half-close requires --data")
+	}
+
 	// Open message file, if applicable
 	if messageFileName != "" {
 		if messageFile, err := os.Open(messageFileName); err != nil {
@@ -344,6 +588,16 @@ func init() {
 			config.ExternalClientHello = clientHello
 		}
 	}
+
+	// Open TLS key log, if applicable
+	if keyLogFileName != "" {
+		keyLogFile, err := os.OpenFile(keyLogFileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			zlog.Fatal(err)
+		}
+		zlog.Warn("writing TLS master secrets to", keyLogFileName)
+		config.TLSKeyLogWriter = keyLogFile
+	}
 }
 
 func main() {
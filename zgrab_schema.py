@@ -172,6 +172,7 @@ zgrab_lint = SubRecord({})
 zgrab_certificate = SubRecord({
     "raw":Binary(),
     "parsed":zgrab_parsed_certificate,
+    "parse_error":String(),
     "validation":SubRecord({
         "nss":zgrab_certificate_trust,
         "apple":zgrab_certificate_trust,
@@ -189,10 +190,58 @@ zgrab_server_certificate_valid = SubRecord({
     "error":String()
 })
 
+zgrab_sct = SubRecord({
+    "parsed":SubRecord({
+        "version":Unsigned16BitInteger(),
+        "log_id":IndexedBinary(),
+        "timestamp":Signed64BitInteger(),
+        "signature":Binary(),
+    }),
+    "raw":Binary(),
+    "source":String(),
+})
+
+zgrab_dh_params = SubRecord({
+    "prime":SubRecord({
+        "value":Binary(),
+        "length":Signed32BitInteger(),
+    }),
+    "generator":SubRecord({
+        "value":Binary(),
+        "length":Signed32BitInteger(),
+    }),
+    "server_public":SubRecord({
+        "value":Binary(),
+        "length":Signed32BitInteger(),
+    }),
+})
+
+zgrab_ecdh_params = SubRecord({
+    "curve_id":SubRecord({
+        "name":String(),
+        "id":Signed32BitInteger(),
+    }),
+    "server_public":SubRecord({
+        "x":SubRecord({
+            "value":Binary(),
+            "length":Signed32BitInteger(),
+        }),
+        "y":SubRecord({
+            "value":Binary(),
+            "length":Signed32BitInteger(),
+        }),
+    }),
+})
+
 zgrab_tls = SubRecord({
     "client_hello":SubRecord({
         "random":Binary(),
         "extended_random":Binary(),
+        "max_fragment_length":Signed32BitInteger(),
+        "encrypt_then_mac":Boolean(),
+        "alps_protocols":ListOf(String()),
+        "cert_compression_algorithms":ListOf(String()),
+        "padding_length":Signed32BitInteger(),
     }),
     "server_hello":SubRecord({
         "version":SubRecord({
@@ -206,22 +255,34 @@ zgrab_tls = SubRecord({
             "name":String(),
             "value":Signed32BitInteger(),
         }),
+        "cipher_suite_class":SubRecord({
+            "export":Boolean(),
+            "anonymous":Boolean(),
+            "null":Boolean(),
+            "rc4":Boolean(),
+            "3des":Boolean(),
+            "cbc":Boolean(),
+            "aead":Boolean(),
+            "forward_secret":Boolean(),
+        }),
         "compression_method":Signed32BitInteger(),
+        "server_name_ack":Boolean(),
+        "max_fragment_length":Signed32BitInteger(),
         "ocsp_stapling":Boolean(),
         "ticket":Boolean(),
         "secure_renegotiation":Boolean(),
+        "secure_renegotiation_signal":String(),
+        "renegotiation_info":Binary(),
         "heartbeat":Boolean(),
         "extended_random":Binary(),
         "extended_master_secret": Boolean(),
-        "scts":ListOf(SubRecord({
-                "parsed":SubRecord({
-                    "version":Unsigned16BitInteger(),
-                    "log_id":IndexedBinary(),
-                    "timestamp":Signed64BitInteger(),
-                    "signature":Binary(),
-                 }),
-                "raw":Binary()
-            })),
+        "encrypt_then_mac":Boolean(),
+        "alps":Boolean(),
+        "scts":ListOf(zgrab_sct),
+        "extensions":ListOf(SubRecord({
+            "type":Unsigned16BitInteger(),
+            "data":Binary(),
+        })),
     }),
     "server_certificates":SubRecord({
         "certificate":zgrab_certificate,
@@ -236,43 +297,25 @@ zgrab_tls = SubRecord({
                 "android":zgrab_server_certificate_valid,
             })
         }),
+        "must_staple":Boolean(),
+        "must_staple_violation":Boolean(),
+        "leaf_key":SubRecord({
+            "algorithm":String(),
+            "bits":Signed32BitInteger(),
+            "exponent":Signed32BitInteger(),
+            "curve":String(),
+        }),
+        "chain_truncated":Boolean(),
+        "compression":String(),
     }),
     "server_key_exchange":SubRecord({
-        "ecdh_params":SubRecord({
-            "curve_id":SubRecord({
-                "name":String(),
-                "id":Signed32BitInteger(),
-            }),
-            "server_public":SubRecord({
-                "x":SubRecord({
-                    "value":Binary(),
-                    "length":Signed32BitInteger(),
-                }),
-                "y":SubRecord({
-                    "value":Binary(),
-                    "length":Signed32BitInteger(),
-                }),
-            }),
-        }),
+        "ecdh_params":zgrab_ecdh_params,
         "rsa_params":SubRecord({
             "exponent":Signed64BitInteger(),
             "modulus":Binary(),
             "length":Signed32BitInteger(),
         }),
-        "dh_params":SubRecord({
-            "prime":SubRecord({
-                "value":Binary(),
-                "length":Signed32BitInteger(),
-            }),
-            "generator":SubRecord({
-                "value":Binary(),
-                "length":Signed32BitInteger(),
-            }),
-            "server_public":SubRecord({
-                "value":Binary(),
-                "length":Signed32BitInteger(),
-           }),
-        }),
+        "dh_params":zgrab_dh_params,
         "digest": Binary(),
         "signature":SubRecord({
             "raw":Binary(),
@@ -287,6 +330,16 @@ zgrab_tls = SubRecord({
                 "value":Signed32BitInteger()
             }),
         }),
+        "signature_algorithm":SubRecord({
+            "signature":SubRecord({
+                "name":String(),
+                "value":Signed32BitInteger(),
+            }),
+            "hash":SubRecord({
+                "name":String(),
+                "value":Signed32BitInteger(),
+            }),
+        }),
         "signature_error":String(),
     }),
     "server_finished":SubRecord({
@@ -310,6 +363,19 @@ zgrab_tls = SubRecord({
     "client_finished":SubRecord({
         "verify_data":Binary()
     }),
+    "inappropriate_fallback":Boolean(),
+    "warning_alerts":ListOf(String()),
+    "unrecognized_name":Boolean(),
+    "handshake_record_sizes":ListOf(Signed32BitInteger()),
+    "ocsp_response":Binary(),
+    "sct_list":ListOf(zgrab_sct),
+    "sufficient_scts":Boolean(),
+    "config_fingerprint":Binary(),
+    "malformation":SubRecord({
+        "kind":String(),
+        "outcome":String(),
+        "alert":String(),
+    }),
     "client_key_exchange":SubRecord({
         "dh_params":SubRecord({
             "prime":SubRecord({
@@ -360,11 +426,39 @@ zgrab_base = Record({
     "ip":IPv4Address(required=True),
     "timestamp":DateTime(required=True),
     "domain":String(),
-    "data":SubRecord({}),
+    "data":SubRecord({
+        "is_tls":Boolean(),
+        "attempts":Signed32BitInteger(),
+        "raw_banner":Binary(),
+        "bytes_read":Signed64BitInteger(),
+        "bytes_written":Signed64BitInteger(),
+        "write_fragmentation":SubRecord({
+            "size":Signed32BitInteger(),
+            "delay_us":Signed64BitInteger(),
+        }),
+        "half_close":SubRecord({
+            "response":String(),
+            "outcome":String(),
+        }),
+        "dns":SubRecord({
+            "name":String(),
+            "addresses":ListOf(String()),
+            "resolved_ip":String(),
+            "lookup_time_us":Signed64BitInteger(),
+        }),
+        "connect":SubRecord({
+            "connect_only":Boolean(),
+            "remote_addr":String(),
+            "local_addr":String(),
+            "connect_time_us":Signed64BitInteger(),
+        }),
+    }),
     "error":String(),
     "error_component":String()
 })
 
+zschema.registry.register_schema("zgrab-connect", zgrab_base)
+
 zgrab_banner = Record({
     "data":SubRecord({
         "banner":String()
@@ -392,9 +486,231 @@ zgrab_telnet = Record({
 
 zschema.registry.register_schema("zgrab-telnet", zgrab_telnet)
 
+zgrab_irc = Record({
+    "data":SubRecord({
+        "irc":SubRecord({
+            "server_name":String(),
+            "version":String(),
+            "welcome":String(),
+            "isupport":ListOf(String()),
+            "capabilities":ListOf(String()),
+            "tls_capability":Boolean(),
+            "raw":String(),
+            "line_truncated":Boolean(),
+        })
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-irc", zgrab_irc)
+
+zgrab_whois = Record({
+    "data":SubRecord({
+        "whois":SubRecord({
+            "query":String(),
+            "response":AnalyzedString(),
+            "truncated":Boolean(),
+        })
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-whois", zgrab_whois)
+
+zgrab_mqtt = Record({
+    "data":SubRecord({
+        "mqtt":SubRecord({
+            "protocol_level":Signed32BitInteger(),
+            "return_code":Signed32BitInteger(),
+            "return_code_name":String(),
+            "session_present":Boolean(),
+            "anonymous_allowed":Boolean(),
+            "properties":Binary(),
+        })
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-mqtt", zgrab_mqtt)
+
+zgrab_sip = Record({
+    "data":SubRecord({
+        "sip":SubRecord({
+            "status_line":String(),
+            "status_code":Signed32BitInteger(),
+            "server":String(),
+            "user_agent":String(),
+            "allow":ListOf(String()),
+            "body":String(),
+        })
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-sip", zgrab_sip)
+
+zgrab_finger = Record({
+    "data":SubRecord({
+        "finger":SubRecord({
+            "query":String(),
+            "response":AnalyzedString(),
+            "truncated":Boolean(),
+        })
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-finger", zgrab_finger)
+
+zgrab_gopher = Record({
+    "data":SubRecord({
+        "gopher":SubRecord({
+            "selector":String(),
+            "response":AnalyzedString(),
+            "truncated":Boolean(),
+            "menu":ListOf(SubRecord({
+                "type":String(),
+                "display":String(),
+                "selector":String(),
+                "host":String(),
+                "port":Signed32BitInteger(),
+            })),
+        })
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-gopher", zgrab_gopher)
+
+zgrab_tls_version = SubRecord({
+    "name":String(),
+    "value":Signed32BitInteger()
+})
+
+zgrab_version_intolerance = SubRecord({
+    "probes":ListOf(SubRecord({
+        "client_version":zgrab_tls_version,
+        "success":Boolean(),
+        "server_version":zgrab_tls_version,
+        "error":String(),
+    })),
+})
+
+zgrab_cipher_suite = SubRecord({
+    "hex":String(),
+    "name":String(),
+    "value":Signed32BitInteger(),
+})
+
+zgrab_cipher_preference = SubRecord({
+    "probes":ListOf(SubRecord({
+        "offered":ListOf(zgrab_cipher_suite),
+        "selected":zgrab_cipher_suite,
+    })),
+    "server_has_cipher_preference":Boolean(),
+    "error":String(),
+})
+
+zgrab_padding_probe = SubRecord({
+    "success":Boolean(),
+    "padding_length":Signed32BitInteger(),
+    "error":String(),
+})
+
+zgrab_client_hello_padding = SubRecord({
+    "unpadded":zgrab_padding_probe,
+    "padded":zgrab_padding_probe,
+    "changed":Boolean(),
+})
+
+zgrab_tls_profile = SubRecord({
+    "versions":ListOf(SubRecord({
+        "version":zgrab_tls_version,
+        "supported":Boolean(),
+        "cipher_suites":ListOf(zgrab_cipher_suite),
+        "server_cipher_preference":Boolean(),
+    })),
+    "forward_secrecy":Boolean(),
+    "dh_params":zgrab_dh_params,
+    "dh_security_level":Signed32BitInteger(),
+    "ecdh_params":zgrab_ecdh_params,
+    "handshakes":Signed32BitInteger(),
+    "truncated":Boolean(),
+})
+
+zgrab_tls_flat = SubRecord({
+    "has_server_hello":Boolean(),
+    "version":Unsigned16BitInteger(),
+    "version_name":String(),
+    "cipher_suite":Unsigned16BitInteger(),
+    "cipher_suite_name":String(),
+    "cipher_forward_secret":Boolean(),
+    "cipher_aead":Boolean(),
+    "cipher_export":Boolean(),
+    "compression_method":Signed32BitInteger(),
+    "session_id":String(),
+    "server_name_ack":Boolean(),
+    "ocsp_stapling":Boolean(),
+    "ticket":Boolean(),
+    "secure_renegotiation":Boolean(),
+    "heartbeat":Boolean(),
+    "extended_master_secret":Boolean(),
+    "encrypt_then_mac":Boolean(),
+    "server_extensions":ListOf(Signed32BitInteger()),
+    "has_certificates":Boolean(),
+    "leaf_sha256":String(),
+    "leaf_subject_dn":String(),
+    "leaf_issuer_dn":String(),
+    "leaf_serial_number":String(),
+    "leaf_not_before":Signed64BitInteger(),
+    "leaf_not_after":Signed64BitInteger(),
+    "leaf_parse_error":String(),
+    "leaf_key_algorithm":String(),
+    "leaf_key_bits":Signed32BitInteger(),
+    "leaf_key_curve":String(),
+    "chain_sha256":ListOf(String()),
+    "chain_truncated":Boolean(),
+    "browser_trusted":Boolean(),
+    "browser_error":String(),
+    "must_staple":Boolean(),
+    "must_staple_violation":Boolean(),
+    "cert_compression":String(),
+    "has_server_key_exchange":Boolean(),
+    "key_exchange":String(),
+    "rsa_export_modulus_bits":Signed32BitInteger(),
+    "dh_prime_bits":Signed32BitInteger(),
+    "dh_generator":Signed64BitInteger(),
+    "dh_security_level":Signed32BitInteger(),
+    "ecdh_curve_id":Unsigned16BitInteger(),
+    "ecdh_curve_name":String(),
+    "signature_valid":Boolean(),
+    "signature_error":String(),
+    "has_session_ticket":Boolean(),
+    "session_ticket_length":Signed32BitInteger(),
+    "session_ticket_lifetime_hint":Unsigned32BitInteger(),
+    "server_finished":Boolean(),
+    "inappropriate_fallback":Boolean(),
+    "warning_alerts":ListOf(String()),
+    "unrecognized_name":Boolean(),
+    "handshake_record_sizes":ListOf(Signed32BitInteger()),
+    "ocsp_response_length":Signed32BitInteger(),
+    "sct_count":Signed32BitInteger(),
+    "sufficient_scts":Boolean(),
+    "config_fingerprint":String(),
+})
+
+zgrab_ticket_key_reuse = SubRecord({
+    "tickets":ListOf(Binary()),
+    "key_names":ListOf(Binary()),
+    "resumed":Boolean(),
+    "estimate":String(),
+    "error":String(),
+})
+
 zgrab_tls_banner = Record({
     "data":SubRecord({
         "tls":zgrab_tls,
+        "nested_tls":zgrab_tls,
+        "tls_flat":zgrab_tls_flat,
+        "version_intolerance":zgrab_version_intolerance,
+        "cipher_preference":zgrab_cipher_preference,
+        "client_hello_padding":zgrab_client_hello_padding,
+        "tls_profile":zgrab_tls_profile,
+        "ticket_key_reuse":zgrab_ticket_key_reuse,
     })
 }, extends=zgrab_banner)
 zschema.registry.register_schema("zgrab-imaps", zgrab_tls_banner)
@@ -405,12 +721,64 @@ zgrab_starttls = Record({
         "starttls":String(),
     })
 }, extends=zgrab_tls_banner)
-zschema.registry.register_schema("zgrab-imap", zgrab_starttls)
 zschema.registry.register_schema("zgrab-pop3", zgrab_starttls)
 
+zgrab_imap = Record({
+    "data":SubRecord({
+        "imap_id":SubRecord({
+            "response":String(),
+            "fields":SubRecord({
+                "name":String(),
+                "version":String(),
+                "os":String(),
+                "os-version":String(),
+                "vendor":String(),
+                "support-url":String(),
+                "address":String(),
+                "date":String(),
+                "command":String(),
+                "arguments":String(),
+                "environment":String(),
+            }),
+        }),
+        "imap_capability":SubRecord({
+            "pre_tls_response":String(),
+            "pre_tls":ListOf(String()),
+            "post_tls_response":String(),
+            "post_tls":ListOf(String()),
+            "added":ListOf(String()),
+            "removed":ListOf(String()),
+        }),
+    })
+}, extends=zgrab_starttls)
+zschema.registry.register_schema("zgrab-imap", zgrab_imap)
+
+zgrab_smtp_command = SubRecord({
+    "command":String(),
+    "response":String(),
+    "code":Signed32BitInteger(),
+})
+
 zgrab_smtp = Record({
     "data":SubRecord({
+        "smtp_greeting":SubRecord({
+            "lines":Signed32BitInteger(),
+            "line_delays_us":ListOf(Signed64BitInteger()),
+            "total_us":Signed64BitInteger(),
+            "tarpit":Boolean(),
+        }),
         "ehlo":String(),
+        "helo":String(),
+        "smtp_hello":String(),
+        "starttls_stripped":Boolean(),
+        "smtp_pipelined":Boolean(),
+        "smtp_vrfy":zgrab_smtp_command,
+        "smtp_expn":zgrab_smtp_command,
+        "starttls_injection":SubRecord({
+            "injected":String(),
+            "response":String(),
+            "vulnerable":Boolean(),
+        }),
     })
 }, extends=zgrab_starttls)
 zschema.registry.register_schema("zgrab-smtp", zgrab_smtp)
@@ -522,23 +890,121 @@ zgrab_http_response = SubRecord({
     "status_line":AnalyzedString(),
     "status_code":Signed32BitInteger(),
     "body":HTML(),
+    "body_utf8":HTML(),
+    "body_charset_unsupported":String(),
+    "body_truncated":Boolean(),
+    "chunked_decode_failed":Boolean(),
+    "chunked_decode_error":String(),
+    "smuggling_indicators":ListOf(String()),
+    "body_framing":String(),
     "body_sha256":HexString(),
+    "content_encoding":String(),
+    "security_headers":SubRecord({
+        "strict_transport_security":SubRecord({
+            "max_age":Signed64BitInteger(),
+            "include_subdomains":Boolean(),
+            "preload":Boolean(),
+            "valid":Boolean(),
+        }),
+        "content_security_policy":String(),
+        "x_frame_options":String(),
+        "x_content_type_options":String(),
+    }),
     "headers":zgrab_http_headers,
+    "raw_headers":String(),
+    "ordered_headers":ListOf(SubRecord({
+        "name":String(),
+        "value":String(),
+    })),
     "content_length":Signed32BitInteger(),
     "request":zgrab_http_request
 })
 
+zgrab_http_exchange = SubRecord({
+    "request":SubRecord({
+        "method":String(),
+        "endpoint":String(),
+        "user_agent":String(),
+        "authorization":String(),
+        "version":String(),
+    }),
+    "response":SubRecord({
+        "version_major":Signed32BitInteger(),
+        "version_minor":Signed32BitInteger(),
+        "status_code":Signed32BitInteger(),
+        "status_line":AnalyzedString(),
+        "body":HTML(),
+        "body_utf8":HTML(),
+        "body_charset_unsupported":String(),
+        "body_truncated":Boolean(),
+        "body_sha256":HexString(),
+        "chunked_decode_failed":Boolean(),
+        "chunked_decode_error":String(),
+        "body_framing":String(),
+        "raw_headers":String(),
+        "ordered_headers":ListOf(SubRecord({
+            "name":String(),
+            "value":String(),
+        })),
+        "interim_responses":ListOf(SubRecord({
+            "version_major":Signed32BitInteger(),
+            "version_minor":Signed32BitInteger(),
+            "status_code":Signed32BitInteger(),
+            "status_line":AnalyzedString(),
+            "raw_headers":String(),
+        })),
+        "time_to_first_byte_us":Signed64BitInteger(),
+        "smuggling_indicators":ListOf(String()),
+    }),
+    "reconnected":Boolean(),
+})
+
+zgrab_http_pipelined = ListOf(zgrab_http_exchange)
+
 zgrab_http = Record({
     "data":SubRecord({
       "http":SubRecord({
         "response":zgrab_http_response,
-        "redirect_response_chain":ListOf(zgrab_http_response)
+        "redirect_response_chain":ListOf(zgrab_http_response),
+        "default_vhost_response":zgrab_http_response,
+        "default_vhost_error":String(),
+        "pipelined":zgrab_http_pipelined,
+        "exposed_git_head":Boolean(),
+        "exposed_env":Boolean(),
       })
     })
 }, extends=zgrab_base)
 
 zschema.registry.register_schema("zgrab-http", zgrab_http)
 
+zgrab_auto_probe = Record({
+    "data":SubRecord({
+        "banner":String(),
+        "ehlo":String(),
+        "auto_probe":SubRecord({
+            "service":String(),
+            "confidence":Float(),
+            "reason":String(),
+            "http":zgrab_http_exchange,
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+zschema.registry.register_schema("zgrab-auto", zgrab_auto_probe)
+
+zgrab_http_well_known = Record({
+    "data":SubRecord({
+        "well_known":SubRecord({
+            "paths":ListOf(String()),
+            "findings":ListOf(SubRecord({
+                "path":String(),
+                "finding":String(),
+            })),
+        }),
+    })
+}, extends=zgrab_http)
+zschema.registry.register_schema("zgrab-http-well-known", zgrab_http_well_known)
+
 zgrab_http_proxy = Record({
     "data":SubRecord({
       "http":SubRecord({
@@ -0,0 +1,89 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"strings"
+	"time"
+)
+
+// Services AutoProbe can classify a connection as
+const (
+	ServiceUnknown = "unknown"
+	ServiceHTTP    = "http"
+	ServiceHTTPS   = "https"
+	ServiceTLS     = "tls"
+	ServiceSMTP    = "smtp"
+	ServiceSSH     = "ssh"
+	ServicePOP3    = "pop3"
+	ServiceIMAP    = "imap"
+	ServiceFTP     = "ftp"
+)
+
+// autoProbeBannerWait is how long AutoProbe waits for the server to speak
+// first before assuming it expects the client to, unless
+// ConnConfig.ProactiveBannerTimeout is set.
+const autoProbeBannerWait = 2 * time.Second
+
+// autoProbeEHLODomain is the domain AutoProbe sends in EHLO
+const autoProbeEHLODomain = "zgrab.local"
+
+// An AutoProbeLog records how AutoProbe classified a service. Confidence
+// ranges from 0, a guess, to 1, an unambiguous protocol greeting.
+type AutoProbeLog struct {
+	Service    string        `json:"service"`
+	Confidence float64       `json:"confidence"`
+	Reason     string        `json:"reason,omitempty"`
+	HTTP       *HTTPExchange `json:"http,omitempty"`
+}
+
+// classifyBanner guesses the service from the first bytes a server sent
+// unprompted.
+func classifyBanner(banner []byte) (service string, confidence float64, reason string) {
+	s := string(banner)
+	lower := strings.ToLower(s)
+	switch {
+	case len(banner) == 0:
+		return ServiceUnknown, 0, "no banner"
+	case banner[0] == 0x16:
+		return ServiceTLS, 0.9, "TLS handshake record"
+	case banner[0] == 0x15:
+		return ServiceTLS, 0.6, "TLS alert record"
+	case strings.HasPrefix(s, "SSH-"):
+		return ServiceSSH, 1, "SSH version string"
+	case strings.HasPrefix(s, "HTTP/"):
+		return ServiceHTTP, 0.9, "HTTP status line"
+	case strings.HasPrefix(s, "+OK"):
+		return ServicePOP3, 0.9, "+OK greeting"
+	case strings.HasPrefix(s, "* OK") || strings.HasPrefix(s, "* PREAUTH"):
+		return ServiceIMAP, 0.9, "untagged IMAP greeting"
+	case strings.HasPrefix(s, "220"):
+		// SMTP and FTP both greet with 220; the text usually tells them apart
+		if strings.Contains(lower, "ftp") {
+			return ServiceFTP, 0.9, "220 greeting mentioning FTP"
+		}
+		if strings.Contains(lower, "smtp") || strings.Contains(lower, "mail") {
+			return ServiceSMTP, 0.9, "220 greeting mentioning SMTP"
+		}
+		return ServiceSMTP, 0.5, "bare 220 greeting"
+	}
+	return ServiceUnknown, 0, "unrecognized banner"
+}
+
+// looksLikeTLSRecord reports whether b starts with a TLS handshake or alert
+// record, as a TLS server sends in reply to plaintext.
+func looksLikeTLSRecord(b []byte) bool {
+	return len(b) >= 3 && (b[0] == 0x15 || b[0] == 0x16) && b[1] == 0x03
+}
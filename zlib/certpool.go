@@ -0,0 +1,55 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"errors"
+	"io/ioutil"
+	"sync"
+
+	"github.com/zmap/zcrypto/x509"
+)
+
+var (
+	mozillaRootsOnce sync.Once
+	mozillaRoots     *x509.CertPool
+	mozillaRootsErr  error
+)
+
+// NewCertPoolFromPEM parses every certificate in the PEM file at path into a
+// new pool. The pool is safe to share between Conns as long as it is not
+// modified after it is built.
+func NewCertPoolFromPEM(path string) (*x509.CertPool, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, errors.New("Could not read certificates from PEM file. Invalid PEM?")
+	}
+	return pool, nil
+}
+
+// MozillaRootPool returns a pool loaded once from the system trust store and
+// shared by all callers. zgrab does not bundle a root store; on most Linux
+// distributions the system store is generated from the Mozilla (NSS) root
+// program. Callers must not add certificates to the returned pool.
+func MozillaRootPool() (*x509.CertPool, error) {
+	mozillaRootsOnce.Do(func() {
+		mozillaRoots, mozillaRootsErr = x509.SystemCertPool()
+	})
+	return mozillaRoots, mozillaRootsErr
+}
@@ -0,0 +1,195 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// charsetSniffSize is how much of a body is searched for a <meta> charset
+// declaration, following the HTML prescan limit
+const charsetSniffSize = 1024
+
+// windows1252 maps the bytes 0x80-0x9F of windows-1252 to their code points.
+// The remaining bytes coincide with ISO-8859-1. Undefined bytes decode to
+// the replacement character.
+var windows1252 = [32]rune{
+	0x20AC, 0xFFFD, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0xFFFD, 0x017D, 0xFFFD,
+	0xFFFD, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0xFFFD, 0x017E, 0x0178,
+}
+
+// charsetFromContentType returns the lowercased charset parameter of a
+// Content-Type header value, or the empty string if there is none.
+func charsetFromContentType(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(params["charset"]))
+}
+
+// sniffCharset returns the lowercased charset a <meta> tag in the start of
+// an HTML body declares, either as <meta charset> or in an http-equiv
+// Content-Type, or the empty string if there is none.
+func sniffCharset(body []byte) string {
+	if len(body) > charsetSniffSize {
+		body = body[:charsetSniffSize]
+	}
+	head := bytes.ToLower(body)
+	for {
+		i := bytes.Index(head, []byte("<meta"))
+		if i < 0 {
+			return ""
+		}
+		head = head[i+len("<meta"):]
+		tag := head
+		if end := bytes.IndexByte(tag, '>'); end >= 0 {
+			tag = tag[:end]
+		}
+		j := bytes.Index(tag, []byte("charset"))
+		if j < 0 {
+			continue
+		}
+		value := bytes.TrimLeft(tag[j+len("charset"):], " \t\r\n")
+		if len(value) == 0 || value[0] != '=' {
+			continue
+		}
+		value = bytes.TrimLeft(value[1:], " \t\r\n\"'")
+		if end := bytes.IndexAny(value, " \t\r\n\"';/"); end >= 0 {
+			value = value[:end]
+		}
+		if len(value) > 0 {
+			return string(value)
+		}
+	}
+}
+
+// bodyCharset returns the charset declared in contentType, falling back to
+// one declared in the body itself.
+func bodyCharset(body []byte, contentType string) string {
+	if charset := charsetFromContentType(contentType); charset != "" {
+		return charset
+	}
+	return sniffCharset(body)
+}
+
+// charsetDecoder returns the function decodeBodyToUTF8 uses for charset,
+// and false if the charset cannot be decoded.
+func charsetDecoder(charset string) (func([]byte) []byte, bool) {
+	switch charset {
+	case "iso-8859-1", "iso8859-1", "latin1", "l1":
+		return func(body []byte) []byte { return decodeSingleByte(body, nil) }, true
+	case "windows-1252", "cp1252":
+		return func(body []byte) []byte { return decodeSingleByte(body, &windows1252) }, true
+	case "shift_jis", "shift-jis", "sjis", "x-sjis", "windows-31j", "ms932", "ms_kanji", "csshiftjis":
+		return decodeShiftJIS, true
+	}
+	return nil, false
+}
+
+// unsupportedCharset returns the charset declared for body if it is neither
+// UTF-8 nor ASCII, which need no decoding, nor one decodeBodyToUTF8 handles.
+// It returns the empty string otherwise.
+func unsupportedCharset(body []byte, contentType string) string {
+	charset := bodyCharset(body, contentType)
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return ""
+	}
+	if _, ok := charsetDecoder(charset); ok {
+		return ""
+	}
+	return charset
+}
+
+// decodeBodyToUTF8 transcodes body from the charset declared in contentType,
+// or in a <meta> tag if contentType has none. It returns false if the
+// charset is absent, already UTF-8, or not one we know how to decode;
+// callers should keep the raw body in that case.
+func decodeBodyToUTF8(body []byte, contentType string) (string, bool) {
+	decode, ok := charsetDecoder(bodyCharset(body, contentType))
+	if !ok {
+		return "", false
+	}
+	buf := decode(body)
+	if !utf8.Valid(buf) {
+		return "", false
+	}
+	return string(buf), true
+}
+
+// decodeSingleByte decodes ISO-8859-1, with table replacing the bytes
+// 0x80-0x9F if it is not nil.
+func decodeSingleByte(body []byte, table *[32]rune) []byte {
+	buf := make([]byte, 0, len(body)+len(body)/2)
+	for _, b := range body {
+		r := rune(b)
+		if table != nil && b >= 0x80 && b <= 0x9F {
+			r = table[b-0x80]
+		}
+		buf = append(buf, string(r)...)
+	}
+	return buf
+}
+
+// decodeShiftJIS decodes Shift_JIS as the WHATWG Encoding Standard does.
+// Bytes that do not form a valid sequence decode to the replacement
+// character; an invalid ASCII trail byte is decoded on its own.
+func decodeShiftJIS(body []byte) []byte {
+	buf := make([]byte, 0, len(body)+len(body)/2)
+	for i := 0; i < len(body); i++ {
+		b := body[i]
+		if b <= 0x80 {
+			buf = append(buf, string(rune(b))...)
+			continue
+		}
+		if b >= 0xA1 && b <= 0xDF {
+			buf = append(buf, string(rune(0xFF61)+rune(b-0xA1))...)
+			continue
+		}
+		if b == 0xA0 || b > 0xFC || i+1 == len(body) {
+			buf = append(buf, string(utf8.RuneError)...)
+			continue
+		}
+		t := body[i+1]
+		lead := int(b) - 0x81
+		if b >= 0xE0 {
+			lead = int(b) - 0xC1
+		}
+		trail := -1
+		if t >= 0x40 && t <= 0x7E {
+			trail = int(t) - 0x40
+		} else if t >= 0x80 && t <= 0xFC {
+			trail = int(t) - 0x41
+		}
+		if trail >= 0 {
+			if r := shiftJISTable[lead*188+trail]; r != 0 {
+				buf = append(buf, string(rune(r))...)
+				i++
+				continue
+			}
+		}
+		buf = append(buf, string(utf8.RuneError)...)
+		if t >= 0x80 {
+			i++
+		}
+	}
+	return buf
+}
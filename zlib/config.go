@@ -15,8 +15,10 @@
 package zlib
 
 import (
+	"io"
 	"time"
 
+	"github.com/zmap/zcrypto/tls"
 	"github.com/zmap/zcrypto/x509"
 	"github.com/zmap/zgrab/ztools/zlog"
 )
@@ -25,10 +27,29 @@ type HTTPConfig struct {
 	Method                   string
 	Endpoint                 string
 	UserAgent                string
+	AcceptEncoding           string
 	ProxyDomain              string
+	ProxyConnectHost         string
+	ProxyConnectPort         uint16
 	MaxSize                  int
 	MaxRedirects             int
+	MaxTotalTime             time.Duration
 	FollowLocalhostRedirects bool
+	DecodeCharset            bool
+	BasicAuthUser            string
+	BasicAuthPassword        string
+	BearerToken              string
+	RedactAuth               bool
+	RawHeaders               bool
+	PreserveHeaderCase       bool
+
+	// ProtocolVersion is "HTTP/1.0" to send HTTP/1.0 requests, which also
+	// stops the connection being kept alive. Anything else means HTTP/1.1.
+	ProtocolVersion string
+
+	// ProbeDefaultVhost sends a second request with a nonexistent Host
+	// header to capture the server's default virtual host.
+	ProbeDefaultVhost bool
 }
 
 type XSSHScanConfig struct {
@@ -47,6 +68,34 @@ type Config struct {
 	Senders            uint
 	ConnectionsPerHost uint
 
+	// Socket options. A zero TCPKeepAlive keeps the default, a negative
+	// one disables keepalives. A nil TCPLinger keeps the OS default.
+	TCPKeepAlive time.Duration
+	TCPLinger    *int
+
+	// ConnectOnly skips all protocol interaction, see Conn.ConnectOnly
+	ConnectOnly bool
+
+	// AutoProbe guesses the service and probes it, see Conn.AutoProbe
+	AutoProbe bool
+
+	// RawBannerSize, if positive, records that many bytes off the wire,
+	// see Conn.SetRawBannerCapture
+	RawBannerSize int
+
+	// ProactiveBannerTimeout, if set, waits that long for a banner before
+	// anything is written, see Conn.ReadProactiveBanner
+	ProactiveBannerTimeout time.Duration
+
+	// WriteFragmentSize, if positive, splits writes into pieces of that
+	// many bytes, WriteFragmentDelay apart, see Conn.SetWriteFragmentSize
+	WriteFragmentSize  int
+	WriteFragmentDelay time.Duration
+
+	// Retries on transient errors, see isTransientError
+	MaxAttempts  uint
+	RetryBackoff time.Duration
+
 	// DNS
 	LookupDomain bool
 
@@ -66,37 +115,104 @@ type Config struct {
 	SafariOnly                    bool
 	SafariNoDHE                   bool
 	NoSNI                         bool
+	MultipleSNI                   []string
+	EmptySNI                      bool
 	TLSExtendedRandom             bool
 	GatherSessionTicket           bool
 	ExtendedMasterSecret          bool
+	EncryptThenMAC                bool
+	TLSALPS                       bool
+	TLSCertCompression            bool
+	TLSClientHelloPadding         bool
+	TLSClientHelloMalformation    tls.ClientHelloMalformation
 	TLSVerbose                    bool
 	SignedCertificateTimestampExt bool
 	ExternalClientHello           []byte
+	TLSKeyLogWriter               io.Writer
 	TLSCertsOnly                  bool
+	TLSMaxCertChainLength         int
+	TLSLimitCertParsing           bool
+	TLSParseCertLimit             int
+	TLSVersionIntolerance         bool
+	TLSCipherPreference           bool
+	TLSPaddingProbe               bool
+	TLSProfile                    bool
+	TLSNested                     bool
+	TLSFlat                       bool
+	TLSProfileMaxHandshakes       int
+	TLSTicketKeyReuse             int
+	TLSFallbackSCSV               bool
+	TLSMaxFragmentLength          uint8
+	TLSClientHelloRecordVersion   uint16
+	TLSForceCipher                uint16
+	TLSCurves                     []tls.CurveID
 
 	// Banners and Data
-	Banners  bool
-	SendData bool
-	Data     []byte
-	Raw      bool
+	Banners     bool
+	BannerLines int
+	SendData    bool
+	Data        []byte
+	HalfClose   bool
+	Raw         bool
+
+	// HTTPPipeline lists endpoints to request over the banner connection
+	// with Conn.HTTPMulti, using the other HTTP options
+	HTTPPipeline []string
+
+	// HTTPWellKnown probes the banner connection for well-known paths, see
+	// Conn.ProbeWellKnownPaths
+	HTTPWellKnown      bool
+	HTTPWellKnownPaths []string
 
 	// Mail
-	SMTP       bool
-	IMAP       bool
-	POP3       bool
-	SMTPHelp   bool
-	EHLODomain string
-	EHLO       bool
-	StartTLS   bool
+	SMTP                  bool
+	IMAP                  bool
+	POP3                  bool
+	SMTPHelp              bool
+	SMTPVrfy              string
+	SMTPExpn              string
+	IMAPID                bool
+	IMAPCapability        bool
+	SMTPStartTLSInjection bool
+	SMTPPipelining        bool
+	SMTPGreetingWait      time.Duration
+	EHLODomain            string
+	EHLO                  bool
+	StartTLS              bool
+	ImplicitTLS           bool
 
 	// FTP
 	FTP        bool
 	FTPAuthTLS bool
+	FTPProt    bool
 
 	// Telnet
 	Telnet        bool
 	TelnetMaxSize int
 
+	// IRC
+	IRC     bool
+	IRCNick string
+
+	// Whois
+	WhoisQuery   string
+	WhoisMaxSize int
+
+	// MQTT
+	MQTT              bool
+	MQTTProtocolLevel uint8
+
+	// SIP
+	SIP bool
+
+	// Finger
+	Finger      bool
+	FingerQuery string
+
+	// Gopher
+	Gopher         bool
+	GopherSelector string
+
 	// Modbus
 	Modbus bool
 
@@ -16,9 +16,11 @@ package zlib
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -31,6 +33,7 @@ import (
 	"github.com/zmap/zcrypto/tls"
 	"github.com/zmap/zcrypto/x509"
 	"github.com/zmap/zgrab/ztools/ftp"
+	zhttp "github.com/zmap/zgrab/ztools/http"
 	"github.com/zmap/zgrab/ztools/scada/bacnet"
 	"github.com/zmap/zgrab/ztools/util"
 )
@@ -43,41 +46,154 @@ const (
 	SMTP_COMMAND = "STARTTLS\r\n"
 	POP3_COMMAND = "STLS\r\n"
 	IMAP_COMMAND = "a001 STARTTLS\r\n"
+	IMAP_ID      = "a003 ID NIL\r\n"
+	IMAP_CAPA    = "a002 CAPABILITY\r\n"
 )
 
+// malformationTimeout bounds the wait for the server's reply to a malformed
+// ClientHello, so a server that hangs does not hold the grab for the full
+// timeout
+const malformationTimeout = 5 * time.Second
+
+// ConnConfig holds the options that control how a Conn performs its grabs.
+// The zero value is usable.
+type ConnConfig struct {
+	// Max TLS version
+	MaxTLSVersion uint16
+
+	// Domain is used for SNI and HTTP Host headers
+	Domain string
+
+	CAPool *x509.CertPool
+
+	CipherSuites                  []uint16
+	ForceSuites                   bool
+	CurvePreferences              []tls.CurveID
+	NoSNI                         bool
+	SNIList                       []string
+	EmptySNI                      bool
+	ExternalClientHello           []byte
+	ExtendedRandom                bool
+	GatherSessionTicket           bool
+	OfferExtendedMasterSecret     bool
+	OfferEncryptThenMAC           bool
+	OfferALPS                     bool
+	OfferCertCompression          bool
+	ClientHelloPadding            bool
+	ClientHelloMalformation       tls.ClientHelloMalformation
+	TLSVerbose                    bool
+	TLSCertsOnly                  bool
+	MaxCertChainLength            int
+	LimitCertParsing              bool
+	ParseCertLimit                int
+	MaxFragmentLength             uint8
+	FallbackSCSV                  bool
+	ClientHelloRecordVersion      uint16
+	SignedCertificateTimestampExt bool
+
+	// ImplicitTLS negotiates TLS before the SMTP, POP3 or IMAP banner is
+	// read, rather than upgrading with STARTTLS.
+	ImplicitTLS bool
+
+	// KeyLogWriter receives TLS master secrets in NSS key log format. This
+	// is for debugging only: anyone with the log can decrypt the traffic.
+	KeyLogWriter io.Writer
+
+	// MQTTProtocolLevel is the level MQTTProbe offers, defaulting to
+	// MQTTProtocolLevel311
+	MQTTProtocolLevel byte
+
+	// WellKnownPaths are the paths ProbeWellKnownPaths requests, defaulting
+	// to DefaultWellKnownPaths
+	WellKnownPaths []string
+
+	// ProactiveBannerTimeout is how long to wait for a server that speaks
+	// first, see SetExpectProactiveBanner
+	ProactiveBannerTimeout time.Duration
+
+	// SMTPGreetingWait bounds how long SMTPBanner waits for the whole
+	// greeting, see SetSMTPGreetingWait
+	SMTPGreetingWait time.Duration
+
+	// SMTPPipeliningKnown lets SMTPPipelinedStartTLSHandshake send STARTTLS
+	// without waiting for the EHLO reply, see SetSMTPPipeliningKnown
+	SMTPPipeliningKnown bool
+
+	// RawBannerSize is how many bytes SetRawBannerCapture records
+	RawBannerSize int
+
+	// WriteFragmentSize and WriteFragmentDelay control how writes are
+	// split, see SetWriteFragmentSize
+	WriteFragmentSize  int
+	WriteFragmentDelay time.Duration
+}
+
 // Implements the net.Conn interface
 type Conn struct {
+	ConnConfig
+
 	// Underlying network connection
 	conn    net.Conn
 	tlsConn *tls.Conn
 	isTls   bool
 
-	grabData GrabData
+	// connectTime is how long the dial took, when made by Dialer
+	connectTime time.Duration
 
-	// Max TLS version
-	maxTlsVersion uint16
+	// Socket options set with SetTCPKeepAlive and SetTCPLinger, reapplied
+	// when redial replaces the connection
+	keepAlive *tcpKeepAlive
+	linger    *int
+
+	grabData GrabData
 
 	// Cache the deadlines so we can reapply after TLS handshake
 	readDeadline  time.Time
 	writeDeadline time.Time
 
-	caPool *x509.CertPool
+	// Errored component
+	erroredComponent string
+}
 
-	CipherSuites                  []uint16
-	ForceSuites                   bool
-	noSNI                         bool
-	ExternalClientHello           []byte
-	extendedRandom                bool
-	gatherSessionTicket           bool
-	offerExtendedMasterSecret     bool
-	tlsVerbose                    bool
-	tlsCertsOnly                  bool
-	SignedCertificateTimestampExt bool
+// NewConn wraps an established connection, such as a Unix socket or one end
+// of a net.Pipe, so the grab methods can be driven without dialing.
+func NewConn(conn net.Conn) *Conn {
+	c := &Conn{conn: conn}
+	c.countBytes()
+	return c
+}
 
-	domain string
+// NewConnWithConfig is like NewConn but applies all options in config at once
+func NewConnWithConfig(conn net.Conn, config ConnConfig) *Conn {
+	c := &Conn{ConnConfig: config, conn: conn}
+	c.countBytes()
+	c.fragmentWrites()
+	c.captureRawBanner()
+	return c
+}
 
-	// Errored component
-	erroredComponent string
+// Reset prepares c for a new target over conn. Recorded results, TLS state,
+// the connect time, deadlines and the errored component are cleared;
+// ConnConfig is kept, including Domain, which callers should update with
+// SetDomain.
+func (c *Conn) Reset(conn net.Conn) {
+	c.conn = conn
+	c.tlsConn = nil
+	c.isTls = false
+	c.connectTime = 0
+	c.grabData = GrabData{}
+	c.readDeadline = time.Time{}
+	c.writeDeadline = time.Time{}
+	c.erroredComponent = ""
+	c.countBytes()
+	c.fragmentWrites()
+	c.captureRawBanner()
+}
+
+// GrabData returns the results recorded on c so far. The returned value is
+// owned by c and is updated by further grab methods.
+func (c *Conn) GrabData() *GrabData {
+	return &c.grabData
 }
 
 func (c *Conn) getUnderlyingConn() net.Conn {
@@ -91,28 +207,77 @@ func (c *Conn) SetExternalClientHello(clientHello []byte) {
 	c.ExternalClientHello = clientHello
 }
 
+// SetKeyLogWriter logs TLS master secrets to w, see ConnConfig.KeyLogWriter.
+func (c *Conn) SetKeyLogWriter(w io.Writer) {
+	c.KeyLogWriter = w
+}
+
 func (c *Conn) SetExtendedRandom() {
-	c.extendedRandom = true
+	c.ExtendedRandom = true
 }
 
 func (c *Conn) SetCAPool(pool *x509.CertPool) {
-	c.caPool = pool
+	c.CAPool = pool
 }
 
 func (c *Conn) SetDomain(domain string) {
-	c.domain = domain
+	c.Domain = domain
 }
 
 func (c *Conn) SetNoSNI() {
-	c.noSNI = true
+	c.NoSNI = true
+}
+
+// SetMultipleSNI sends every name in names in the server_name extension,
+// regardless of the domain. This produces a malformed ClientHello and is
+// meant for measuring how servers handle more than one host_name.
+func (c *Conn) SetMultipleSNI(names []string) {
+	c.SNIList = names
+}
+
+// SetEmptySNI controls sending a server_name extension containing a single
+// zero-length host_name. Unlike SetNoSNI, the extension is still present.
+func (c *Conn) SetEmptySNI(empty bool) {
+	c.EmptySNI = empty
 }
 
 func (c *Conn) SetGatherSessionTicket() {
-	c.gatherSessionTicket = true
+	c.GatherSessionTicket = true
 }
 
 func (c *Conn) SetOfferExtendedMasterSecret() {
-	c.offerExtendedMasterSecret = true
+	c.OfferExtendedMasterSecret = true
+}
+
+// SetOfferEncryptThenMAC offers the RFC 7366 encrypt_then_mac extension so
+// the ServerHello records whether the server supports it.
+func (c *Conn) SetOfferEncryptThenMAC() {
+	c.OfferEncryptThenMAC = true
+}
+
+// SetOfferALPS offers ALPN and the ALPS extension the way Chrome does. It is
+// meant to be used with the Chrome cipher suites.
+func (c *Conn) SetOfferALPS() {
+	c.OfferALPS = true
+}
+
+// SetOfferCertCompression offers zlib certificate compression (RFC 8879)
+// and decompresses the Certificate message if the server uses it
+func (c *Conn) SetOfferCertCompression() {
+	c.OfferCertCompression = true
+}
+
+// SetClientHelloPadding adds the RFC 7685 padding extension to ClientHellos
+// of 256 to 511 bytes, which some F5 appliances drop
+func (c *Conn) SetClientHelloPadding() {
+	c.ClientHelloPadding = true
+}
+
+// SetClientHelloMalformation corrupts the ClientHello extensions block as
+// described by kind. The server's reaction is recorded in the handshake log
+// and the wait for it is bounded by malformationTimeout.
+func (c *Conn) SetClientHelloMalformation(kind tls.ClientHelloMalformation) {
+	c.ClientHelloMalformation = kind
 }
 
 func (c *Conn) SetSignedCertificateTimestampExt() {
@@ -120,11 +285,104 @@ func (c *Conn) SetSignedCertificateTimestampExt() {
 }
 
 func (c *Conn) SetTLSVerbose() {
-	c.tlsVerbose = true
+	c.TLSVerbose = true
 }
 
 func (c *Conn) SetTLSCertsOnly() {
-	c.tlsCertsOnly = true
+	c.TLSCertsOnly = true
+}
+
+// SetFallbackSCSV controls offering TLS_FALLBACK_SCSV. Combined with a max
+// TLS version below the server's, a correct server rejects the handshake with
+// an inappropriate_fallback alert.
+func (c *Conn) SetFallbackSCSV(fallback bool) {
+	c.FallbackSCSV = fallback
+}
+
+// SetClientHelloRecordVersion sets the record layer version the ClientHello
+// is sent with, independent of the version offered in it. Zero keeps the
+// TLS 1.0 default.
+func (c *Conn) SetClientHelloRecordVersion(vers uint16) {
+	c.ClientHelloRecordVersion = vers
+}
+
+// ForceCipher offers suite as the only cipher suite, even if it is not
+// implemented, to get a handshake with a specific key exchange. The ClientHello
+// log is kept so the offer is recorded, and TLSHandshake reports an error
+// naming the suite if the server rejects it.
+func (c *Conn) ForceCipher(suite uint16) {
+	c.CipherSuites = []uint16{suite}
+	c.ForceSuites = true
+}
+
+// forcedCipher returns the suite set by ForceCipher, if any
+func (c *Conn) forcedCipher() (uint16, bool) {
+	if c.ForceSuites && len(c.CipherSuites) == 1 {
+		return c.CipherSuites[0], true
+	}
+	return 0, false
+}
+
+// SetSupportedCurves offers only curves in the supported_groups extension, in
+// order. Combined with ForceCipher on an ECDHE suite it tests support for a
+// single named curve; the server's choice is recorded in the ECDH parameters
+// of the ServerKeyExchange.
+func (c *Conn) SetSupportedCurves(curves []tls.CurveID) {
+	c.CurvePreferences = curves
+}
+
+// SetMaxFragmentLength offers the max_fragment_length extension with code,
+// 1 through 4 for 2^9 through 2^12 bytes. Zero omits the extension.
+func (c *Conn) SetMaxFragmentLength(code byte) {
+	c.MaxFragmentLength = code
+}
+
+// SetImplicitTLS sets whether the mail banner methods negotiate TLS first,
+// as on the implicit TLS ports 465, 993 and 995.
+func (c *Conn) SetImplicitTLS(implicit bool) {
+	c.ImplicitTLS = implicit
+}
+
+func (c *Conn) SetMQTTProtocolLevel(level byte) {
+	c.MQTTProtocolLevel = level
+}
+
+// SetExpectProactiveBanner makes ReadProactiveBanner, and AutoProbe, wait
+// at most timeout for the server to send a banner before the client writes.
+func (c *Conn) SetExpectProactiveBanner(timeout time.Duration) {
+	c.ProactiveBannerTimeout = timeout
+}
+
+// SetSMTPPipeliningKnown declares that the server is known to advertise
+// PIPELINING, for example from an earlier grab, so that
+// SMTPPipelinedStartTLSHandshake may pipeline STARTTLS behind EHLO.
+func (c *Conn) SetSMTPPipeliningKnown() {
+	c.SMTPPipeliningKnown = true
+}
+
+// SetSMTPGreetingWait makes SMTPBanner give up on a greeting that has not
+// finished after d, rather than waiting for the read deadline. What arrived
+// is kept as the banner.
+func (c *Conn) SetSMTPGreetingWait(d time.Duration) {
+	c.SMTPGreetingWait = d
+}
+
+func (c *Conn) SetWellKnownPaths(paths []string) {
+	c.WellKnownPaths = paths
+}
+
+// SetMaxCertChainLength caps how many server certificates are parsed and
+// logged. Zero uses the TLS library default; negative disables the cap.
+func (c *Conn) SetMaxCertChainLength(n int) {
+	c.MaxCertChainLength = n
+}
+
+// SetParseCertLimit parses only the first n server certificates. All of them
+// are still logged raw, so a collection scan can set this to 0 or 1 to save
+// the cost of x509 parsing.
+func (c *Conn) SetParseCertLimit(n int) {
+	c.LimitCertParsing = true
+	c.ParseCertLimit = n
 }
 
 // Layer in the regular conn methods
@@ -136,6 +394,207 @@ func (c *Conn) RemoteAddr() net.Addr {
 	return c.getUnderlyingConn().RemoteAddr()
 }
 
+// tcpConn returns the TCP connection underneath any raw capture, byte
+// counting or write fragmentation
+func (c *Conn) tcpConn() (*net.TCPConn, bool) {
+	return unwrapTCPConn(c.conn)
+}
+
+func unwrapTCPConn(conn net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch wrapped := conn.(type) {
+		case *rawCaptureConn:
+			conn = wrapped.Conn
+		case *countingConn:
+			conn = wrapped.Conn
+		case *fragmentingConn:
+			conn = wrapped.Conn
+		default:
+			tcp, ok := conn.(*net.TCPConn)
+			return tcp, ok
+		}
+	}
+}
+
+// countBytes wraps the connection so every byte read or written, including
+// TLS records, is added to GrabData.BytesRead and BytesWritten
+func (c *Conn) countBytes() {
+	if c.conn == nil {
+		return
+	}
+	if _, ok := c.conn.(*countingConn); ok {
+		return
+	}
+	c.conn = &countingConn{Conn: c.conn, read: &c.grabData.BytesRead, written: &c.grabData.BytesWritten}
+}
+
+// countingConn adds the number of bytes read from and written to Conn to
+// read and written
+type countingConn struct {
+	net.Conn
+	read    *int64
+	written *int64
+}
+
+func (cc *countingConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	*cc.read += int64(n)
+	return n, err
+}
+
+func (cc *countingConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	*cc.written += int64(n)
+	return n, err
+}
+
+// SetRawBannerCapture records the first size bytes read off the wire,
+// before TLS or any protocol parsing, in GrabData.RawBanner. It must be
+// called before anything is read.
+func (c *Conn) SetRawBannerCapture(size int) {
+	c.RawBannerSize = size
+	c.captureRawBanner()
+}
+
+func (c *Conn) captureRawBanner() {
+	if c.RawBannerSize <= 0 || c.conn == nil {
+		return
+	}
+	if _, ok := c.conn.(*rawCaptureConn); ok {
+		return
+	}
+	c.conn = &rawCaptureConn{Conn: c.conn, max: c.RawBannerSize, dst: &c.grabData.RawBanner}
+}
+
+// rawCaptureConn copies up to max bytes of what is read from Conn to dst
+type rawCaptureConn struct {
+	net.Conn
+	max int
+	dst *[]byte
+}
+
+func (rc *rawCaptureConn) Read(b []byte) (int, error) {
+	n, err := rc.Conn.Read(b)
+	if room := rc.max - len(*rc.dst); room > 0 && n > 0 {
+		if room > n {
+			room = n
+		}
+		*rc.dst = append(*rc.dst, b[:room]...)
+	}
+	return n, err
+}
+
+// SetWriteFragmentSize splits every write, including the TLS ClientHello,
+// into separate writes of at most n bytes, so that each goes out in its own
+// TCP segment. This defeats middleboxes that only inspect the first packet
+// of a connection. A zero or negative n writes buffers whole.
+func (c *Conn) SetWriteFragmentSize(n int) {
+	c.WriteFragmentSize = n
+	c.fragmentWrites()
+}
+
+// SetWriteFragmentDelay pauses for d between the fragments of a write, see
+// SetWriteFragmentSize
+func (c *Conn) SetWriteFragmentDelay(d time.Duration) {
+	c.WriteFragmentDelay = d
+	if fc, ok := c.conn.(*fragmentingConn); ok {
+		fc.delay = d
+		c.grabData.WriteFragmentation.DelayMicros = int64(d / time.Microsecond)
+	}
+}
+
+func (c *Conn) fragmentWrites() {
+	if c.WriteFragmentSize <= 0 || c.conn == nil {
+		return
+	}
+	if fc, ok := c.conn.(*fragmentingConn); ok {
+		fc.size = c.WriteFragmentSize
+		c.grabData.WriteFragmentation.Size = c.WriteFragmentSize
+		return
+	}
+	c.grabData.WriteFragmentation = &WriteFragmentationLog{
+		Size:        c.WriteFragmentSize,
+		DelayMicros: int64(c.WriteFragmentDelay / time.Microsecond),
+	}
+	c.conn = &fragmentingConn{Conn: c.conn, size: c.WriteFragmentSize, delay: c.WriteFragmentDelay}
+}
+
+// A WriteFragmentationLog records how writes were split, see
+// Conn.SetWriteFragmentSize
+type WriteFragmentationLog struct {
+	Size        int   `json:"size"`
+	DelayMicros int64 `json:"delay_us,omitempty"`
+}
+
+// fragmentingConn writes to Conn in pieces of at most size bytes, sleeping
+// for delay between them
+type fragmentingConn struct {
+	net.Conn
+	size  int
+	delay time.Duration
+}
+
+func (fc *fragmentingConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		if written > 0 && fc.delay > 0 {
+			time.Sleep(fc.delay)
+		}
+		end := written + fc.size
+		if end > len(b) {
+			end = len(b)
+		}
+		n, err := fc.Conn.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+type tcpKeepAlive struct {
+	enabled bool
+	period  time.Duration
+}
+
+// SetTCPKeepAlive sets SO_KEEPALIVE on the underlying connection, and the
+// keepalive period if it is positive. Non-TCP connections are left alone.
+func (c *Conn) SetTCPKeepAlive(keepalive bool, period time.Duration) error {
+	c.keepAlive = &tcpKeepAlive{enabled: keepalive, period: period}
+	return c.applyTCPKeepAlive(c.conn)
+}
+
+func (c *Conn) applyTCPKeepAlive(conn net.Conn) error {
+	tcp, ok := unwrapTCPConn(conn)
+	if !ok || c.keepAlive == nil {
+		return nil
+	}
+	if err := tcp.SetKeepAlive(c.keepAlive.enabled); err != nil {
+		return err
+	}
+	if c.keepAlive.enabled && c.keepAlive.period > 0 {
+		return tcp.SetKeepAlivePeriod(c.keepAlive.period)
+	}
+	return nil
+}
+
+// SetTCPLinger sets SO_LINGER on the underlying connection, see
+// net.TCPConn.SetLinger. Zero makes Close reset the connection, which
+// avoids TIME_WAIT at high connection rates. Non-TCP connections are left
+// alone.
+func (c *Conn) SetTCPLinger(sec int) error {
+	c.linger = &sec
+	return c.applyTCPLinger(c.conn)
+}
+
+func (c *Conn) applyTCPLinger(conn net.Conn) error {
+	if tcp, ok := unwrapTCPConn(conn); ok && c.linger != nil {
+		return tcp.SetLinger(*c.linger)
+	}
+	return nil
+}
+
 func (c *Conn) SetDeadline(t time.Time) error {
 	c.readDeadline = t
 	c.writeDeadline = t
@@ -163,7 +622,23 @@ func (c *Conn) BasicBanner() (string, error) {
 	b := make([]byte, 1024)
 	n, err := c.getUnderlyingConn().Read(b)
 	c.grabData.Banner = string(b[0:n])
-	return c.grabData.Banner, err
+	return c.grabData.Banner, readTimeoutError(n, err)
+}
+
+// LineBanner reads until maxLines CRLF-terminated lines have been received
+// and stores them as the banner. Fewer lines followed by the server closing
+// the connection is not an error.
+func (c *Conn) LineBanner(b []byte, maxLines int) (int, error) {
+	if maxLines < 1 || maxLines > 1000 {
+		return 0, fmt.Errorf("line count %d out of range", maxLines)
+	}
+	lineEndRegex := regexp.MustCompile(fmt.Sprintf(`^(?:.*\r\n){%d}`, maxLines))
+	n, err := util.ReadUntilRegex(c.getUnderlyingConn(), b, lineEndRegex)
+	c.grabData.Banner = string(b[0:n])
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, readTimeoutError(n, err)
 }
 
 func (c *Conn) Read(b []byte) (int, error) {
@@ -182,8 +657,8 @@ func (c *Conn) makeHTTPRequest(endpoint string, httpMethod string, userAgent str
 	}
 	url := new(url.URL)
 	var host string
-	if len(c.domain) > 0 {
-		host = c.domain
+	if len(c.Domain) > 0 {
+		host = c.Domain
 	} else {
 		host, _, _ = net.SplitHostPort(c.RemoteAddr().String())
 	}
@@ -208,59 +683,358 @@ func (c *Conn) makeHTTPRequest(endpoint string, httpMethod string, userAgent str
 	encReq.Endpoint = endpoint
 	encReq.Method = httpMethod
 	encReq.UserAgent = userAgent
+	encReq.Version = req.Proto
 	return req, encReq, nil
 }
 
 func (c *Conn) makeHTTPRequestFromConfig(config *HTTPConfig) (req *http.Request, encReq *HTTPRequest, err error) {
-	return c.makeHTTPRequest(config.Endpoint, config.Method, config.UserAgent)
+	if req, encReq, err = c.makeHTTPRequest(config.Endpoint, config.Method, config.UserAgent); err != nil {
+		return
+	}
+	if config.AcceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", config.AcceptEncoding)
+	}
+	if auth := config.authorization(); auth != "" {
+		req.Header.Set("Authorization", auth)
+		encReq.Authorization = config.recordedAuthorization()
+	}
+	if config.http10() {
+		req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.0", 1, 0
+		encReq.Version = req.Proto
+	}
+	return
+}
+
+// writeHTTPRequest writes req to w in wire format. net/http always writes
+// an HTTP/1.1 request line, so it is rewritten for HTTP/1.0 requests.
+func writeHTTPRequest(w io.Writer, req *http.Request) error {
+	if req.ProtoMajor != 1 || req.ProtoMinor != 0 {
+		return req.Write(w)
+	}
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	if i := bytes.Index(b, []byte(" HTTP/1.1\r\n")); i >= 0 {
+		copy(b[i:], " HTTP/1.0\r\n")
+	}
+	_, err := w.Write(b)
+	return err
 }
 
 func (c *Conn) sendHTTPRequestReadHTTPResponse(req *http.Request, config *HTTPConfig) (encRes *HTTPResponse, err error) {
 	uc := c.getUnderlyingConn()
-	if err = req.Write(uc); err != nil {
+	if err = writeHTTPRequest(uc, req); err != nil {
 		return
 	}
 	if req.Method == "CONNECT" {
 		req.Method = "HEAD" // fuck you golang
 	}
-	reader := bufio.NewReader(uc)
-	var res *http.Response
-	if res, err = http.ReadResponse(reader, req); err != nil {
-		msg := err.Error()
-		if len(msg) > 1024*config.MaxSize {
-			err = errors.New(msg[0 : 1024*config.MaxSize])
+	hr := newHTTPResponseReader(uc)
+	ttfb := hr.waitFirstByte()
+	if encRes, _, err = hr.read(req, config); err == nil {
+		encRes.TimeToFirstByteMicros = ttfb
+	}
+	return
+}
+
+// httpResponseReader reads successive responses from one connection, so
+// that pipelined responses buffered past the current one aren't lost.
+type httpResponseReader struct {
+	headers *headerRecorder
+	raw     *rawBodyRecorder
+	reader  *bufio.Reader
+}
+
+func newHTTPResponseReader(r io.Reader) *httpResponseReader {
+	headers := &headerRecorder{r: r}
+	raw := &rawBodyRecorder{r: headers}
+	return &httpResponseReader{
+		headers: headers,
+		raw:     raw,
+		reader:  bufio.NewReader(raw),
+	}
+}
+
+// waitFirstByte blocks until the first byte of a response has arrived and
+// returns how long that took in microseconds, or 0 if the read failed.
+func (hr *httpResponseReader) waitFirstByte() int64 {
+	start := time.Now()
+	if _, err := hr.reader.Peek(1); err != nil {
+		return 0
+	}
+	return int64(time.Since(start) / time.Microsecond)
+}
+
+// read reads the response to req. The returned http.Response's body has
+// been read up to the configured maximum size but may not be exhausted.
+func (hr *httpResponseReader) read(req *http.Request, config *HTTPConfig) (encRes *HTTPResponse, res *http.Response, err error) {
+	maxLen := 1024 * config.MaxSize
+	reader := hr.reader
+	hr.raw.reset(maxLen)
+	var rawHeaders []byte
+	var interim []*HTTPResponse
+	for {
+		hr.headers.start(reader)
+		if res, err = http.ReadResponse(reader, req); err != nil {
+			// net/http rejects some smuggling attempts, such as
+			// conflicting Content-Length headers, so report those
+			if indicators := zhttp.SmugglingIndicators(hr.headers.stop(reader)); len(indicators) > 0 {
+				encRes = &HTTPResponse{SmugglingIndicators: indicators}
+			}
+			msg := err.Error()
+			if len(msg) > 1024*config.MaxSize {
+				err = errors.New(msg[0 : 1024*config.MaxSize])
+			}
+			return
 		}
-		return
+		rawHeaders = hr.headers.stop(reader)
+		// 100 Continue, 103 Early Hints and the like come before the
+		// final response. 101 ends HTTP on the connection so it is final.
+		if res.StatusCode/100 != 1 || res.StatusCode == http.StatusSwitchingProtocols {
+			break
+		}
+		interim = append(interim, newHTTPResponse(res, rawHeaders, config))
 	}
+	chunked := len(res.TransferEncoding) > 0 && res.TransferEncoding[0] == "chunked"
+	if chunked {
+		hr.raw.start(reader)
+	}
+	framing := bodyFraming(req, res)
+	// Read at most one byte past the limit, so a truncated body is
+	// detected without buffering the rest of it.
 	var body []byte
-	if body, err = ioutil.ReadAll(res.Body); err != nil {
-		msg := err.Error()
-		if len(msg) > 1024*config.MaxSize {
-			err = errors.New(msg[0 : 1024*config.MaxSize])
+	chunkedDecodeFailed := false
+	chunkedDecodeError := ""
+	closedEarly := false
+	if body, err = ioutil.ReadAll(io.LimitReader(res.Body, int64(maxLen)+1)); err != nil {
+		if framing == HTTPFramingContentLength && err == io.ErrUnexpectedEOF {
+			// The server closed before sending Content-Length bytes
+			err = nil
+			closedEarly = true
+		} else if !chunked {
+			msg := err.Error()
+			if len(msg) > 1024*config.MaxSize {
+				err = errors.New(msg[0 : 1024*config.MaxSize])
+			}
+			return
+		} else {
+			// Keep whatever the server sent rather than dropping the response
+			chunkedDecodeError = err.Error()
+			body, err = hr.raw.Bytes(), nil
+			chunkedDecodeFailed = true
 		}
-		return
 	}
-	encRes = new(HTTPResponse)
-	encRes.StatusCode = res.StatusCode
-	encRes.StatusLine = res.Proto + " " + res.Status
-	encRes.VersionMajor = res.ProtoMajor
-	encRes.VersionMinor = res.ProtoMinor
+	encRes = newHTTPResponse(res, rawHeaders, config)
+	encRes.SmugglingIndicators = zhttp.SmugglingIndicators(rawHeaders)
+	encRes.InterimResponses = interim
+	encRes.ChunkedDecodeFailed = chunkedDecodeFailed
+	encRes.ChunkedDecodeError = chunkedDecodeError
+	encRes.BodyFraming = framing
+	encRes.BodyTruncated = closedEarly
 	//	encRes.Headers = HeadersFromGolangHeaders(res.Header)
-	var bodyOutput []byte
-	if len(body) > 1024*config.MaxSize {
-		bodyOutput = body[0 : 1024*config.MaxSize]
-	} else {
-		bodyOutput = body
+	bodyOutput := body
+	if len(body) > maxLen {
+		bodyOutput = body[0:maxLen]
+		encRes.BodyTruncated = true
 	}
 	encRes.Body = string(bodyOutput)
+	if config.DecodeCharset {
+		encRes.BodyUTF8, _ = decodeBodyToUTF8(bodyOutput, res.Header.Get("Content-Type"))
+		encRes.BodyCharsetUnsupported = unsupportedCharset(bodyOutput, res.Header.Get("Content-Type"))
+	}
 	if len(bodyOutput) > 0 {
 		m := sha256.New()
 		m.Write(bodyOutput)
 		encRes.BodySHA256 = m.Sum(nil)
 	}
-	return encRes, nil
+	return encRes, res, nil
+}
+
+// newHTTPResponse records the status line and headers of res. rawHeaders is
+// the header block as received, if it was kept.
+func newHTTPResponse(res *http.Response, rawHeaders []byte, config *HTTPConfig) *HTTPResponse {
+	encRes := new(HTTPResponse)
+	encRes.StatusCode = res.StatusCode
+	encRes.StatusLine = res.Proto + " " + res.Status
+	encRes.VersionMajor = res.ProtoMajor
+	encRes.VersionMinor = res.ProtoMinor
+	encRes.SecurityHeaders = zhttp.ParseSecurityHeaders(zhttp.Header(res.Header))
+	if config.RawHeaders {
+		encRes.RawHeaders = string(rawHeaders)
+	}
+	if config.PreserveHeaderCase {
+		encRes.OrderedHeaders = zhttp.ParseHeaderFields(rawHeaders)
+	}
+	return encRes
+}
+
+// bodyFraming returns how the end of res's body is marked, following the
+// rules of RFC 7230 section 3.3.3
+func bodyFraming(req *http.Request, res *http.Response) string {
+	switch {
+	case req.Method == "HEAD", res.StatusCode/100 == 1, res.StatusCode == 204, res.StatusCode == 304:
+		return HTTPFramingNone
+	case len(res.TransferEncoding) > 0 && res.TransferEncoding[0] == "chunked":
+		return HTTPFramingChunked
+	case res.ContentLength >= 0:
+		return HTTPFramingContentLength
+	}
+	return HTTPFramingCloseDelimited
+}
+
+// HTTPMulti sends a request for each of configs on the connection without
+// waiting for the responses, then reads the responses in order. If the
+// server closes the connection part way through, a new connection is made
+// and the unanswered requests are sent again.
+func (c *Conn) HTTPMulti(configs []*HTTPConfig) error {
+	if c.grabData.HTTP == nil {
+		c.grabData.HTTP = new(HTTP)
+	}
+	h := c.grabData.HTTP
+	reconnected := false
+	for len(configs) > 0 {
+		if reconnected {
+			if err := c.redial(); err != nil {
+				return err
+			}
+		}
+		var batch bytes.Buffer
+		reqs := make([]*http.Request, len(configs))
+		exchanges := make([]*HTTPExchange, len(configs))
+		for i, config := range configs {
+			req, encReq, err := c.makeHTTPRequestFromConfig(config)
+			if err != nil {
+				return err
+			}
+			if err := writeHTTPRequest(&batch, req); err != nil {
+				return err
+			}
+			reqs[i] = req
+			exchanges[i] = &HTTPExchange{Request: encReq}
+		}
+		exchanges[0].Reconnected = reconnected
+		uc := c.getUnderlyingConn()
+		if _, err := uc.Write(batch.Bytes()); err != nil {
+			return err
+		}
+		hr := newHTTPResponseReader(uc)
+		n := 0
+		for n < len(reqs) {
+			encRes, res, err := hr.read(reqs[n], configs[n])
+			h.Pipelined = append(h.Pipelined, exchanges[n])
+			exchanges[n].Response = encRes
+			if err != nil {
+				return err
+			}
+			n++
+			if res.Close {
+				break
+			}
+			// Drain anything past the size limit so the next response
+			// starts where the reader expects it. A body too large to drain
+			// is abandoned with the connection, and the remaining requests
+			// go out on a new one.
+			limit := int64(1024 * configs[n-1].MaxSize)
+			drained, err := io.Copy(ioutil.Discard, io.LimitReader(res.Body, limit+1))
+			if err != nil {
+				return err
+			}
+			if drained > limit {
+				break
+			}
+		}
+		configs = configs[n:]
+		reconnected = true
+	}
+	return nil
+}
+
+// ProbeWellKnownPaths requests each of WellKnownPaths with HTTPMulti, using
+// config for everything but the endpoint, and records which responses look
+// notable, such as an exposed .git/HEAD.
+func (c *Conn) ProbeWellKnownPaths(config *HTTPConfig) error {
+	paths := c.WellKnownPaths
+	if len(paths) == 0 {
+		paths = DefaultWellKnownPaths
+	}
+	e := &WellKnownLog{Paths: paths}
+	c.grabData.WellKnown = e
+	configs := make([]*HTTPConfig, len(paths))
+	for i, path := range paths {
+		pathConfig := *config
+		pathConfig.Endpoint = path
+		configs[i] = &pathConfig
+	}
+	var start int
+	if c.grabData.HTTP != nil {
+		start = len(c.grabData.HTTP.Pipelined)
+	}
+	err := c.HTTPMulti(configs)
+	for _, ex := range c.grabData.HTTP.Pipelined[start:] {
+		if ex.Response == nil || ex.Response.StatusCode != http.StatusOK {
+			continue
+		}
+		if finding := wellKnownFinding(ex.Request.Endpoint, ex.Response.Body); finding != "" {
+			e.Findings = append(e.Findings, WellKnownFinding{Path: ex.Request.Endpoint, Finding: finding})
+		}
+	}
+	return err
+}
+
+// dialAgain opens another connection to addr, wrapped like the first one:
+// its bytes are counted in GrabData, its writes are fragmented and the TCP
+// socket options set on c are applied.
+func (c *Conn) dialAgain(addr net.Addr, deadline time.Time) (net.Conn, error) {
+	d := net.Dialer{Deadline: deadline}
+	conn, err := d.Dial(addr.Network(), addr.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := c.applyTCPKeepAlive(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.applyTCPLinger(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn = &countingConn{Conn: conn, read: &c.grabData.BytesRead, written: &c.grabData.BytesWritten}
+	if c.WriteFragmentSize > 0 {
+		conn = &fragmentingConn{Conn: conn, size: c.WriteFragmentSize, delay: c.WriteFragmentDelay}
+	}
+	return conn, nil
+}
+
+// redial replaces the connection with a new one to the same address, see
+// dialAgain, repeating the TLS handshake if the old connection used TLS.
+// The handshake recorded for the first connection is kept.
+func (c *Conn) redial() error {
+	addr := c.conn.RemoteAddr()
+	useTLS := c.isTls
+	c.Close()
+	conn, err := c.dialAgain(addr, c.writeDeadline)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.tlsConn = nil
+	c.isTls = false
+	conn.SetReadDeadline(c.readDeadline)
+	conn.SetWriteDeadline(c.writeDeadline)
+	if !useTLS {
+		return nil
+	}
+	hl, isTLS := c.grabData.TLSHandshake, c.grabData.IsTLS
+	err = c.TLSHandshake()
+	c.grabData.TLSHandshake, c.grabData.IsTLS = hl, isTLS
+	return err
 }
 
+// doProxy sends a CONNECT for config.ProxyConnectHost, or ProxyDomain, and
+// records the exchange. Nothing calls it yet: the HTTP grab has no proxy
+// support, so the proxy options in HTTPConfig have no effect.
 func (c *Conn) doProxy(config *HTTPConfig) error {
 	req, encReq, err := c.makeHTTPRequestFromConfig(config)
 	if err != nil {
@@ -272,6 +1046,14 @@ func (c *Conn) doProxy(config *HTTPConfig) error {
 	c.grabData.HTTP.ProxyRequest = encReq
 	req.Method = "CONNECT"
 	req.URL.Path = config.ProxyDomain
+	if config.ProxyConnectHost != "" {
+		// JoinHostPort brackets IPv6 literals, which a bare ProxyDomain can't express
+		port := config.ProxyConnectPort
+		if port == 0 {
+			port = 443
+		}
+		req.URL.Path = net.JoinHostPort(config.ProxyConnectHost, strconv.Itoa(int(port)))
+	}
 	encReq.Method = req.Method
 	encReq.Endpoint = req.URL.Path
 	var encRes *HTTPResponse
@@ -285,47 +1067,89 @@ func (c *Conn) doProxy(config *HTTPConfig) error {
 	return nil
 }
 
-// Extra method - Do a TLS Handshake and record progress
-func (c *Conn) TLSHandshake() error {
-	if c.isTls {
-		return fmt.Errorf(
-			"Attempted repeat handshake with remote host %s",
-			c.RemoteAddr().String())
-	}
+// buildTLSConfig returns a tls.Config reflecting the options set on c
+func (c *Conn) buildTLSConfig() *tls.Config {
 	tlsConfig := new(tls.Config)
-	tlsConfig.CertsOnly = c.tlsCertsOnly
+	tlsConfig.CertsOnly = c.TLSCertsOnly
+	tlsConfig.MaxCertChainLength = c.MaxCertChainLength
+	tlsConfig.LimitCertParsing = c.LimitCertParsing
+	tlsConfig.ParseCertLimit = c.ParseCertLimit
+	tlsConfig.FallbackSCSV = c.FallbackSCSV
+	tlsConfig.MaxFragmentLength = c.MaxFragmentLength
+	tlsConfig.ClientHelloRecordVersion = c.ClientHelloRecordVersion
 	tlsConfig.InsecureSkipVerify = true
 	tlsConfig.MinVersion = tls.VersionSSL30
-	tlsConfig.MaxVersion = c.maxTlsVersion
-	tlsConfig.RootCAs = c.caPool
+	tlsConfig.MaxVersion = c.MaxTLSVersion
+	tlsConfig.RootCAs = c.CAPool
 	tlsConfig.HeartbeatEnabled = true
 	tlsConfig.ClientDSAEnabled = true
 	tlsConfig.ForceSuites = c.ForceSuites
 	tlsConfig.CipherSuites = c.CipherSuites
-	if !c.noSNI && c.domain != "" {
-		tlsConfig.ServerName = c.domain
+	if len(c.CurvePreferences) > 0 {
+		tlsConfig.CurvePreferences = c.CurvePreferences
+		tlsConfig.ExplicitCurvePreferences = true
+	}
+	if !c.NoSNI && c.Domain != "" {
+		tlsConfig.ServerName = c.Domain
+	}
+	if len(c.SNIList) > 0 {
+		tlsConfig.ServerNames = c.SNIList
+	}
+	if c.EmptySNI {
+		tlsConfig.ServerNames = []string{""}
 	}
-	if c.extendedRandom {
+	if c.ExtendedRandom {
 		tlsConfig.ExtendedRandom = true
 	}
 	if c.SignedCertificateTimestampExt {
 		tlsConfig.SignedCertificateTimestampExt = true
 	}
-	if c.gatherSessionTicket {
+	if c.GatherSessionTicket {
 		tlsConfig.ForceSessionTicketExt = true
 	}
-	if c.offerExtendedMasterSecret {
+	if c.OfferExtendedMasterSecret {
 		tlsConfig.ExtendedMasterSecret = true
 	}
+	if c.OfferEncryptThenMAC {
+		tlsConfig.EncryptThenMAC = true
+	}
+	if c.OfferCertCompression {
+		tlsConfig.CertCompressionAlgorithms = []tls.CertCompressionAlgorithm{tls.CertCompressionZlib}
+	}
+	tlsConfig.ClientHelloPadding = c.ClientHelloPadding
+	if c.OfferALPS {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		tlsConfig.ALPSProtocols = []string{"h2"}
+	}
 	if c.ExternalClientHello != nil {
 		tlsConfig.ExternalClientHello = c.ExternalClientHello
 	}
+	tlsConfig.ClientHelloMalformation = c.ClientHelloMalformation
+	tlsConfig.KeyLogWriter = c.KeyLogWriter
+	return tlsConfig
+}
+
+// Extra method - Do a TLS Handshake and record progress
+func (c *Conn) TLSHandshake() error {
+	if c.isTls {
+		return fmt.Errorf(
+			"Attempted repeat handshake with remote host %s",
+			c.RemoteAddr().String())
+	}
+	tlsConfig := c.buildTLSConfig()
 
 	c.tlsConn = tls.Client(c.conn, tlsConfig)
-	c.tlsConn.SetReadDeadline(c.readDeadline)
+	readDeadline := c.readDeadline
+	if c.ClientHelloMalformation != "" {
+		if d := time.Now().Add(malformationTimeout); readDeadline.IsZero() || d.Before(readDeadline) {
+			readDeadline = d
+		}
+	}
+	c.tlsConn.SetReadDeadline(readDeadline)
 	c.tlsConn.SetWriteDeadline(c.writeDeadline)
 	c.isTls = true
 	err := c.tlsConn.Handshake()
+	c.tlsConn.SetReadDeadline(c.readDeadline)
 	if tlsConfig.ForceSuites && err == tls.ErrUnimplementedCipher {
 		err = nil
 	}
@@ -333,18 +1157,76 @@ func (c *Conn) TLSHandshake() error {
 		err = nil
 	}
 	hl := c.tlsConn.GetHandshakeLog()
+	suite, forced := c.forcedCipher()
+	if forced && err != nil && hl.ServerHello == nil {
+		err = fmt.Errorf("server rejected forced cipher suite %s: %s", tls.CipherSuite(suite), err)
+	} else if len(c.CurvePreferences) > 0 && err != nil && hl.ServerHello == nil {
+		err = fmt.Errorf("server rejected offered curves %v: %s", c.CurvePreferences, err)
+	}
+
+	c.trimHandshakeLog(hl, forced)
+	c.grabData.TLSHandshake = hl
+	c.grabData.IsTLS = c.tlsConn.ConnectionState().HandshakeComplete
+	return err
+}
 
-	if !c.tlsVerbose {
-		hl.KeyMaterial = nil
+// trimHandshakeLog drops the client side of hl unless TLSVerbose is set.
+// The ClientHello is kept if a cipher suite was forced.
+func (c *Conn) trimHandshakeLog(hl *tls.ServerHandshake, forced bool) {
+	if c.TLSVerbose || hl == nil {
+		return
+	}
+	hl.KeyMaterial = nil
+	if !forced {
 		hl.ClientHello = nil
-		hl.ClientFinished = nil
-		hl.ClientKeyExchange = nil
 	}
+	hl.ClientFinished = nil
+	hl.ClientKeyExchange = nil
+}
 
-	c.grabData.TLSHandshake = hl
+// TLSHandshakeNested makes a second TLS handshake inside the established
+// one, for services behind a TLS-terminating front such as stunnel. The
+// inner handshake is recorded in NestedTLSHandshake and later reads and
+// writes go through it. It uses the same options as TLSHandshake.
+func (c *Conn) TLSHandshakeNested() error {
+	if !c.isTls {
+		return fmt.Errorf(
+			"Attempted nested handshake without an outer TLS connection to remote host %s",
+			c.RemoteAddr().String())
+	}
+	if c.grabData.NestedTLSHandshake != nil {
+		return fmt.Errorf(
+			"Attempted repeat nested handshake with remote host %s",
+			c.RemoteAddr().String())
+	}
+	tlsConfig := c.buildTLSConfig()
+	inner := tls.Client(c.tlsConn, tlsConfig)
+	inner.SetReadDeadline(c.readDeadline)
+	inner.SetWriteDeadline(c.writeDeadline)
+	err := inner.Handshake()
+	if tlsConfig.ForceSuites && err == tls.ErrUnimplementedCipher {
+		err = nil
+	}
+	if err == tls.ErrCertsOnly {
+		err = nil
+	}
+	hl := inner.GetHandshakeLog()
+	_, forced := c.forcedCipher()
+	c.trimHandshakeLog(hl, forced)
+	c.grabData.NestedTLSHandshake = hl
+	c.tlsConn = inner
 	return err
 }
 
+// implicitTLSHandshake negotiates TLS if ImplicitTLS is set and the
+// connection is not yet encrypted.
+func (c *Conn) implicitTLSHandshake() error {
+	if !c.ImplicitTLS || c.isTls {
+		return nil
+	}
+	return c.TLSHandshake()
+}
+
 func (c *Conn) sendStartTLSCommand(command string) error {
 	// Don't doublehandshake
 	if c.isTls {
@@ -369,9 +1251,10 @@ func (c *Conn) SMTPStartTLSHandshake() error {
 	buf := make([]byte, 256)
 	n, err := c.readSmtpResponse(buf)
 	c.grabData.StartTLS = string(buf[0:n])
+	err = readTimeoutError(n, err)
 
-	// Actually check return code
-	if n < 5 {
+	// Actually check return code, keeping any read error
+	if err == nil && n < 5 {
 		err = errors.New("Server did not indicate support for STARTTLS")
 	}
 	if err == nil {
@@ -399,6 +1282,7 @@ func (c *Conn) POP3StartTLSHandshake() error {
 	buf := make([]byte, 512)
 	n, err := c.readPop3Response(buf)
 	c.grabData.StartTLS = string(buf[0:n])
+	err = readTimeoutError(n, err)
 	if err == nil {
 		if !strings.HasPrefix(c.grabData.StartTLS, "+") {
 			err = errors.New("Server did not indicate support for STARTTLS")
@@ -419,6 +1303,7 @@ func (c *Conn) IMAPStartTLSHandshake() error {
 	buf := make([]byte, 512)
 	n, err := c.readImapStatusResponse(buf)
 	c.grabData.StartTLS = string(buf[0:n])
+	err = readTimeoutError(n, err)
 	if err == nil {
 		if !strings.HasPrefix(c.grabData.StartTLS, "a001 OK") {
 			err = errors.New("Server did not indicate support for STARTTLS")
@@ -431,26 +1316,188 @@ func (c *Conn) IMAPStartTLSHandshake() error {
 	return c.TLSHandshake()
 }
 
+// SMTPStartTLSInjectionTest sends STARTTLS with a NOOP in the same write,
+// completes the handshake and then waits for an unsolicited reply over TLS.
+// A server that answers the NOOP has carried buffered plaintext across the
+// TLS boundary (the class of bug behind CVE-2011-0411).
+func (c *Conn) SMTPStartTLSInjectionTest() error {
+	e := &StartTLSInjectionLog{Injected: smtpStartTLSInjection}
+	c.grabData.StartTLSInjection = e
+	if err := c.sendStartTLSCommand(SMTP_COMMAND + smtpStartTLSInjection); err != nil {
+		return err
+	}
+	buf := make([]byte, 256)
+	n, err := c.readSmtpResponse(buf)
+	c.grabData.StartTLS = string(buf[0:n])
+	if err != nil {
+		return err
+	}
+	if n < 5 || c.grabData.StartTLS[0] != '2' {
+		return errors.New("Bad return code for STARTTLS")
+	}
+	if err := c.TLSHandshake(); err != nil {
+		return err
+	}
+	response, err := c.readProactiveBanner(startTLSInjectionWait)
+	e.Response = string(response)
+	e.Vulnerable = len(response) > 0
+	return err
+}
+
+// SMTPPipelinedStartTLSHandshake sends EHLO and STARTTLS in a single write
+// and splits both replies out of what comes back, saving a round trip. RFC
+// 2920 only allows this with a server that advertises PIPELINING, so unless
+// SMTPPipeliningKnown is set EHLO is sent alone and STARTTLS follows on the
+// same connection once the reply is in. SMTPPipelined is only recorded if
+// the commands went out together and the EHLO reply advertises PIPELINING.
+func (c *Conn) SMTPPipelinedStartTLSHandshake(domain string) error {
+	if !c.SMTPPipeliningKnown {
+		if err := c.EHLO(domain); err != nil {
+			return err
+		}
+		return c.SMTPStartTLSHandshake()
+	}
+	if err := c.sendStartTLSCommand("EHLO " + domain + "\r\n" + SMTP_COMMAND); err != nil {
+		return err
+	}
+	buf := make([]byte, 1024)
+	length := 0
+	var replies []string
+	for {
+		n, err := c.conn.Read(buf[length:])
+		length += n
+		replies = splitSMTPReplies(string(buf[0:length]))
+		if len(replies) > 0 {
+			c.grabData.EHLO = replies[0]
+		}
+		if len(replies) >= 2 {
+			break
+		}
+		if err != nil {
+			// Keep the partial reply that was being read
+			if len(replies) == 0 {
+				c.grabData.EHLO = string(buf[0:length])
+			} else {
+				c.grabData.StartTLS = string(buf[len(replies[0]):length])
+			}
+			return readTimeoutError(length, err)
+		}
+		if length == len(buf) {
+			return errors.New("Not enough buffer space")
+		}
+	}
+
+	c.grabData.SMTPPipelined = hasEHLOKeyword(replies[0], "PIPELINING")
+	c.grabData.SMTPHello = "EHLO"
+	c.grabData.StartTLS = replies[1]
+	if len(replies[1]) < 5 || replies[1][0] != '2' {
+		return errors.New("Bad return code for STARTTLS")
+	}
+	return c.TLSHandshake()
+}
+
 func (c *Conn) readSmtpResponse(res []byte) (int, error) {
 	return util.ReadUntilRegex(c.getUnderlyingConn(), res, smtpEndRegex)
 }
 
 func (c *Conn) SMTPBanner(b []byte) (int, error) {
-	n, err := c.readSmtpResponse(b)
+	if err := c.implicitTLSHandshake(); err != nil {
+		return 0, err
+	}
+	n, err := c.readSMTPGreeting(b)
 	c.grabData.Banner = string(b[0:n])
-	return n, err
+	return n, readTimeoutError(n, err)
+}
+
+// readSMTPGreeting reads like readSmtpResponse, timing when each line of the
+// greeting arrives, and waits at most SMTPGreetingWait if it is set.
+func (c *Conn) readSMTPGreeting(b []byte) (int, error) {
+	uc := c.getUnderlyingConn()
+	start := time.Now()
+	if c.SMTPGreetingWait > 0 {
+		wait := start.Add(c.SMTPGreetingWait)
+		if c.readDeadline.IsZero() || wait.Before(c.readDeadline) {
+			uc.SetReadDeadline(wait)
+			defer uc.SetReadDeadline(c.readDeadline)
+		}
+	}
+	g := new(SMTPGreetingLog)
+	c.grabData.SMTPGreeting = g
+	last := start
+	length := 0
+	for {
+		n, err := uc.Read(b[length:])
+		now := time.Now()
+		if lines := bytes.Count(b[0:length+n], []byte("\r\n")) - g.Lines; lines > 0 {
+			g.addLines(lines, now.Sub(last))
+			last = now
+		}
+		length += n
+		g.TotalMicros = int64(now.Sub(start) / time.Microsecond)
+		if err != nil {
+			return length, err
+		}
+		if smtpEndRegex.Match(b[0:length]) {
+			return length, nil
+		}
+		if length == len(b) {
+			return length, errors.New("Not enough buffer space")
+		}
+	}
 }
 
+// EHLO sends EHLO and, if the server rejects it with a 5xx, falls back to
+// HELO. Both replies are recorded and SMTPHello names the one accepted.
 func (c *Conn) EHLO(domain string) error {
-	cmd := []byte("EHLO " + domain + "\r\n")
-	if _, err := c.getUnderlyingConn().Write(cmd); err != nil {
+	ehlo, err := c.smtpHello("EHLO", domain)
+	c.grabData.EHLO = ehlo
+	if err != nil {
 		return err
 	}
+	if !strings.HasPrefix(ehlo, "5") {
+		if strings.HasPrefix(ehlo, "2") {
+			c.grabData.SMTPHello = "EHLO"
+		}
+		return nil
+	}
+	helo, err := c.smtpHello("HELO", domain)
+	c.grabData.HELO = helo
+	if strings.HasPrefix(helo, "2") {
+		c.grabData.SMTPHello = "HELO"
+	}
+	return err
+}
 
+func (c *Conn) smtpHello(command, domain string) (string, error) {
+	cmd := []byte(command + " " + domain + "\r\n")
+	if _, err := c.getUnderlyingConn().Write(cmd); err != nil {
+		return "", err
+	}
 	buf := make([]byte, 512)
 	n, err := c.readSmtpResponse(buf)
-	c.grabData.EHLO = string(buf[0:n])
-	return err
+	return string(buf[0:n]), err
+}
+
+// helloResponse returns the HELO reply if the server fell back to HELO,
+// otherwise the EHLO reply.
+func (c *Conn) helloResponse() string {
+	if c.grabData.SMTPHello == "HELO" {
+		return c.grabData.HELO
+	}
+	return c.grabData.EHLO
+}
+
+// CheckSTARTTLSStripping looks for a STARTTLS keyword that was masked in
+// transit in the recorded EHLO response, or the HELO response after a
+// fallback. It must be called after EHLO.
+func (c *Conn) CheckSTARTTLSStripping() bool {
+	for _, keyword := range ehloKeywords(c.helloResponse()) {
+		if isMaskedSTARTTLS(keyword) {
+			c.grabData.STARTTLSStripped = true
+			break
+		}
+	}
+	return c.grabData.STARTTLSStripped
 }
 
 func (c *Conn) SMTPHelp() error {
@@ -467,6 +1514,35 @@ func (c *Conn) SMTPHelp() error {
 	return err
 }
 
+func (c *Conn) sendSMTPCommand(command string) (*SMTPCommandEvent, error) {
+	e := &SMTPCommandEvent{Command: command}
+	if _, err := c.getUnderlyingConn().Write([]byte(command + "\r\n")); err != nil {
+		return e, err
+	}
+	buf := make([]byte, 512)
+	n, err := c.readSmtpResponse(buf)
+	e.Response = string(buf[0:n])
+	if n >= 3 {
+		e.Code, _ = strconv.Atoi(e.Response[0:3])
+	}
+	return e, err
+}
+
+// SMTPVrfy sends VRFY for user. A 250 or 251 reply indicates the server
+// permits user enumeration.
+func (c *Conn) SMTPVrfy(user string) error {
+	e, err := c.sendSMTPCommand("VRFY " + user)
+	c.grabData.SMTPVrfy = e
+	return err
+}
+
+// SMTPExpn sends EXPN for list.
+func (c *Conn) SMTPExpn(list string) error {
+	e, err := c.sendSMTPCommand("EXPN " + list)
+	c.grabData.SMTPExpn = e
+	return err
+}
+
 func (c *Conn) SMTPQuit() error {
 	cmd := []byte("QUIT\r\n")
 	_, err := c.getUnderlyingConn().Write(cmd)
@@ -478,9 +1554,12 @@ func (c *Conn) readPop3Response(res []byte) (int, error) {
 }
 
 func (c *Conn) POP3Banner(b []byte) (int, error) {
+	if err := c.implicitTLSHandshake(); err != nil {
+		return 0, err
+	}
 	n, err := c.readPop3Response(b)
 	c.grabData.Banner = string(b[0:n])
-	return n, err
+	return n, readTimeoutError(n, err)
 }
 
 func (c *Conn) POP3Quit() error {
@@ -494,9 +1573,91 @@ func (c *Conn) readImapStatusResponse(res []byte) (int, error) {
 }
 
 func (c *Conn) IMAPBanner(b []byte) (int, error) {
+	if err := c.implicitTLSHandshake(); err != nil {
+		return 0, err
+	}
 	n, err := c.readImapStatusResponse(b)
 	c.grabData.Banner = string(b[0:n])
-	return n, err
+	return n, readTimeoutError(n, err)
+}
+
+// IMAPID sends an ID command and records the server's identification, if any
+func (c *Conn) IMAPID() error {
+	e := new(IMAPIDEvent)
+	c.grabData.IMAPID = e
+	if _, err := c.getUnderlyingConn().Write([]byte(IMAP_ID)); err != nil {
+		return err
+	}
+	buf := make([]byte, 1024)
+	length := 0
+	for {
+		n, err := c.readImapStatusResponse(buf[length:])
+		length += n
+		e.Response = string(buf[0:length])
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(e.Response, "a003 ") || strings.Contains(e.Response, "\r\na003 ") {
+			break
+		}
+	}
+	e.Fields = parseIMAPID(e.Response)
+	return nil
+}
+
+// IMAPCapability sends a CAPABILITY command and records the reply. Called
+// before STARTTLS it records the plaintext capabilities; called again after
+// the handshake it records the post-TLS ones and what changed between them.
+func (c *Conn) IMAPCapability() error {
+	e := c.grabData.IMAPCapability
+	if e == nil {
+		e = new(IMAPCapabilityEvent)
+		c.grabData.IMAPCapability = e
+	}
+	if _, err := c.getUnderlyingConn().Write([]byte(IMAP_CAPA)); err != nil {
+		return err
+	}
+	buf := make([]byte, 1024)
+	length := 0
+	var response string
+	var err error
+	for {
+		var n int
+		n, err = c.readImapStatusResponse(buf[length:])
+		length += n
+		response = string(buf[0:length])
+		if err != nil || strings.HasPrefix(response, "a002 ") || strings.Contains(response, "\r\na002 ") {
+			break
+		}
+	}
+	caps := parseIMAPCapabilities(response)
+	if !c.isTls {
+		e.PreTLSResponse = response
+		e.PreTLS = caps
+		return err
+	}
+	e.PostTLSResponse = response
+	e.PostTLS = caps
+	if e.PreTLS != nil {
+		e.Added, e.Removed = diffCapabilities(e.PreTLS, e.PostTLS)
+	}
+	return err
+}
+
+// ConnectOnly records that the connection was established, and how long
+// that took, without reading or writing anything.
+func (c *Conn) ConnectOnly() {
+	e := &ConnectLog{
+		ConnectOnly:       true,
+		ConnectTimeMicros: int64(c.connectTime / time.Microsecond),
+	}
+	if addr := c.conn.RemoteAddr(); addr != nil {
+		e.RemoteAddr = addr.String()
+	}
+	if addr := c.conn.LocalAddr(); addr != nil {
+		e.LocalAddr = addr.String()
+	}
+	c.grabData.Connect = e
 }
 
 func (c *Conn) IMAPQuit() error {
@@ -505,6 +1666,299 @@ func (c *Conn) IMAPQuit() error {
 	return err
 }
 
+// IRCProbe registers with an IRC server as nick, asking for its capability
+// list first, and records what the server reveals until the end of the MOTD.
+// PINGs received during registration are answered. A line longer than
+// ircMaxLineSize ends the probe with the part read so far in Raw.
+func (c *Conn) IRCProbe(nick string) error {
+	l := new(IRCLog)
+	c.grabData.IRC = l
+	conn := c.getUnderlyingConn()
+	register := "CAP LS 302\r\nNICK " + nick + "\r\nUSER " + nick + " 0 * :" + nick + "\r\n"
+	if _, err := conn.Write([]byte(register)); err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(conn, ircMaxLineSize)
+	var raw []byte
+	for len(raw) < ircMaxResponseSize {
+		line, err := reader.ReadSlice('\n')
+		raw = append(raw, line...)
+		l.Raw = string(raw)
+		if err == bufio.ErrBufferFull {
+			l.LineTruncated = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		m := parseIRCMessage(string(line))
+		if m.command == "PING" {
+			pong := "PONG"
+			if len(m.params) > 0 {
+				pong += " :" + m.params[len(m.params)-1]
+			}
+			if _, err := conn.Write([]byte(pong + "\r\n")); err != nil {
+				return err
+			}
+			continue
+		}
+		if capLSDone(m) {
+			if _, err := conn.Write([]byte("CAP END\r\n")); err != nil {
+				return err
+			}
+		}
+		if l.handle(m) {
+			break
+		}
+	}
+	_, err := conn.Write([]byte("QUIT\r\n"))
+	return err
+}
+
+// WhoisQuery sends query and records the response up to maxSize bytes. The
+// response ends when the server closes the connection or the deadline passes.
+func (c *Conn) WhoisQuery(query string, maxSize int) error {
+	w := &WhoisEvent{Query: query}
+	c.grabData.Whois = w
+	conn := c.getUnderlyingConn()
+	if _, err := conn.Write([]byte(query + "\r\n")); err != nil {
+		return err
+	}
+	response, truncated, err := readUntilClose(conn, maxSize)
+	w.Response = string(response)
+	w.Truncated = truncated
+	return err
+}
+
+// MQTTProbe sends an MQTT CONNECT without credentials and records whether
+// the broker accepts it
+func (c *Conn) MQTTProbe() error {
+	m := new(MQTTLog)
+	c.grabData.MQTT = m
+	level := c.MQTTProtocolLevel
+	if level == 0 {
+		level = MQTTProtocolLevel311
+	}
+	conn := c.getUnderlyingConn()
+	if _, err := conn.Write(makeMQTTConnect(level)); err != nil {
+		return err
+	}
+	return readMQTTConnack(conn, m)
+}
+
+// SIPProbe sends a SIP OPTIONS request and records the final response,
+// skipping any provisional ones
+func (c *Conn) SIPProbe() error {
+	s := new(SIPLog)
+	c.grabData.SIP = s
+	host := c.Domain
+	if host == "" {
+		host, _, _ = net.SplitHostPort(c.RemoteAddr().String())
+	}
+	conn := c.getUnderlyingConn()
+	if _, err := conn.Write(makeSIPOptions(host, c.LocalAddr().String())); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	for {
+		if err := readSIPResponse(r, s); err != nil {
+			return err
+		}
+		if s.StatusCode >= 200 {
+			return nil
+		}
+	}
+}
+
+// FingerProbe sends a finger query and records the response
+func (c *Conn) FingerProbe(query string) error {
+	f := &FingerEvent{Query: query}
+	c.grabData.Finger = f
+	conn := c.getUnderlyingConn()
+	if _, err := conn.Write([]byte(query + "\r\n")); err != nil {
+		return err
+	}
+	response, truncated, err := readUntilClose(conn, fingerMaxResponseSize)
+	f.Response = string(response)
+	f.Truncated = truncated
+	return err
+}
+
+// GopherProbe sends a gopher selector and records the response, parsing it
+// as a directory listing if it is one
+func (c *Conn) GopherProbe(selector string) error {
+	g := &GopherLog{Selector: selector}
+	c.grabData.Gopher = g
+	conn := c.getUnderlyingConn()
+	if _, err := conn.Write([]byte(selector + "\r\n")); err != nil {
+		return err
+	}
+	response, truncated, err := readUntilClose(conn, gopherMaxResponseSize)
+	g.Response = string(response)
+	g.Truncated = truncated
+	g.Menu = parseGopherMenu(g.Response)
+	return err
+}
+
+// AutoProbe reads the server's banner, guesses the service from it and
+// continues with the matching probe: EHLO for SMTP, CAPABILITY for IMAP,
+// AUTH TLS for FTP and STLS for POP3. A server that opens with TLS or HTTP is
+// sent a TLS handshake or an HTTP request on a new connection, and one that
+// says nothing is sent an HTTP request and, failing that, a TLS handshake.
+// For SSH the version string in the banner is the result. The guess is
+// recorded in GrabData.
+func (c *Conn) AutoProbe() error {
+	e := new(AutoProbeLog)
+	c.grabData.AutoProbe = e
+	timeout := c.ProactiveBannerTimeout
+	if timeout <= 0 {
+		timeout = autoProbeBannerWait
+	}
+	banner, err := c.readProactiveBanner(timeout)
+	c.grabData.Banner = string(banner)
+	if err != nil {
+		return err
+	}
+	e.Service, e.Confidence, e.Reason = classifyBanner(banner)
+	switch {
+	case e.Service == ServiceSMTP:
+		return c.EHLO(autoProbeEHLODomain)
+	case e.Service == ServiceIMAP:
+		return c.IMAPCapability()
+	case e.Service == ServiceFTP:
+		c.grabData.FTP = &ftp.FTPLog{Banner: string(banner)}
+		return c.GetFTPSCertificates()
+	case e.Service == ServicePOP3:
+		return c.POP3StartTLSHandshake()
+	case e.Service == ServiceTLS:
+		if err := c.redial(); err != nil {
+			return err
+		}
+		return c.TLSHandshake()
+	case e.Service == ServiceHTTP:
+		if err := c.redial(); err != nil {
+			return err
+		}
+		ex, _, err := c.autoProbeHTTP(&HTTPConfig{Method: "GET", Endpoint: "/", MaxSize: 256})
+		e.HTTP = ex
+		return err
+	case len(banner) == 0:
+		return c.autoProbeSilent(e)
+	}
+	return nil
+}
+
+// ReadProactiveBanner records whatever the server sends unprompted within
+// ProactiveBannerTimeout, so that a server that speaks first isn't written
+// over and one that waits for the client doesn't stall the grab. It is not
+// an error for the server to send nothing.
+func (c *Conn) ReadProactiveBanner() error {
+	banner, err := c.readProactiveBanner(c.ProactiveBannerTimeout)
+	c.grabData.Banner = string(banner)
+	return err
+}
+
+// readProactiveBanner reads once, waiting at most timeout. A server that
+// sends nothing in that time gives an empty banner.
+func (c *Conn) readProactiveBanner(timeout time.Duration) ([]byte, error) {
+	uc := c.getUnderlyingConn()
+	wait := time.Now().Add(timeout)
+	if !c.readDeadline.IsZero() && c.readDeadline.Before(wait) {
+		wait = c.readDeadline
+	}
+	uc.SetReadDeadline(wait)
+	defer uc.SetReadDeadline(c.readDeadline)
+	buf := make([]byte, 1024)
+	n, err := uc.Read(buf)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		err = nil
+	}
+	return buf[0:n], err
+}
+
+// autoProbeSilent classifies a server that waits for the client to speak
+func (c *Conn) autoProbeSilent(e *AutoProbeLog) error {
+	config := &HTTPConfig{Method: "GET", Endpoint: "/", MaxSize: 256}
+	ex, first, err := c.autoProbeHTTP(config)
+	if err == nil {
+		e.Service, e.Confidence, e.Reason, e.HTTP = ServiceHTTP, 0.9, "HTTP response", ex
+		return nil
+	}
+	// Many TLS servers answer plaintext with an alert or just hang up, so
+	// try a handshake either way.
+	if err := c.redial(); err != nil {
+		return err
+	}
+	if err := c.TLSHandshake(); err != nil {
+		e.Service, e.Confidence, e.Reason = ServiceUnknown, 0, "no response to HTTP or TLS"
+		return nil
+	}
+	e.Service, e.Confidence, e.Reason = ServiceTLS, 0.8, "TLS handshake succeeded"
+	if looksLikeTLSRecord(first) {
+		e.Confidence, e.Reason = 0.9, "TLS record in reply to HTTP"
+	}
+	if ex, _, err := c.autoProbeHTTP(config); err == nil {
+		e.Service, e.HTTP = ServiceHTTPS, ex
+	}
+	return nil
+}
+
+// autoProbeHTTP sends a request for config and reads the response. first
+// holds the first bytes of the reply, even if it wasn't HTTP.
+func (c *Conn) autoProbeHTTP(config *HTTPConfig) (ex *HTTPExchange, first []byte, err error) {
+	req, encReq, err := c.makeHTTPRequestFromConfig(config)
+	if err != nil {
+		return
+	}
+	uc := c.getUnderlyingConn()
+	if err = writeHTTPRequest(uc, req); err != nil {
+		return
+	}
+	hr := newHTTPResponseReader(uc)
+	ttfb := hr.waitFirstByte()
+	peek, _ := hr.reader.Peek(3)
+	first = append([]byte(nil), peek...)
+	if looksLikeTLSRecord(first) {
+		return nil, first, errors.New("TLS record in reply to HTTP")
+	}
+	encRes, _, err := hr.read(req, config)
+	if err != nil {
+		return
+	}
+	encRes.TimeToFirstByteMicros = ttfb
+	return &HTTPExchange{Request: encReq, Response: encRes}, first, nil
+}
+
+// readUntilClose reads from conn until the server closes the connection or
+// maxSize bytes have been read. A timeout after some data has arrived is
+// treated as the end of the response.
+func readUntilClose(conn net.Conn, maxSize int) (response []byte, truncated bool, err error) {
+	buf := make([]byte, maxSize)
+	length := 0
+	for length < maxSize {
+		var n int
+		n, err = conn.Read(buf[length:])
+		length += n
+		if err != nil {
+			break
+		}
+	}
+	if length == maxSize && err == nil {
+		// Only a reply with more to come is truncated
+		var extra [1]byte
+		var n int
+		n, err = conn.Read(extra[:])
+		truncated = n > 0
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() && length > 0 {
+		err = nil
+	}
+	return buf[0:length], truncated, err
+}
+
 func (c *Conn) CheckHeartbleed(b []byte) (int, error) {
 	if !c.isTls {
 		return 0, fmt.Errorf(
@@ -520,6 +1974,290 @@ func (c *Conn) CheckHeartbleed(b []byte) (int, error) {
 	return n, err
 }
 
+// CheckVersionIntolerance offers each version in versionIntoleranceProbes as
+// the ClientHello version, each over a new connection to the same remote
+// host, and records which ones the server answered with a ServerHello. The
+// connection c itself is left untouched.
+func (c *Conn) CheckVersionIntolerance() error {
+	if c.isTls {
+		return fmt.Errorf(
+			"Attempted version intolerance check after TLS handshake with remote host %s",
+			c.RemoteAddr().String())
+	}
+	vi := new(VersionIntoleranceLog)
+	c.grabData.VersionIntolerance = vi
+	for _, vers := range versionIntoleranceProbes {
+		vi.Probes = append(vi.Probes, c.probeClientVersion(vers))
+	}
+	return nil
+}
+
+func (c *Conn) probeClientVersion(vers uint16) VersionProbe {
+	probe := VersionProbe{ClientVersion: tls.TLSVersion(vers)}
+	serverHello, err := c.probeServerHello(func(tlsConfig *tls.Config) {
+		tlsConfig.MaxVersion = vers
+	})
+	if serverHello != nil {
+		probe.Success = true
+		probe.ServerVersion = serverHello.Version
+	}
+	if err != nil {
+		probe.Error = err.Error()
+	}
+	return probe
+}
+
+// CheckServerCipherPreference offers the same two cipher suites in both
+// orders, each over a new connection to the same remote host. A server
+// that selects the same suite both times enforces its own preference. The
+// suites are the first two configured on c, or cipherPreferenceProbeSuites.
+func (c *Conn) CheckServerCipherPreference() error {
+	if c.isTls {
+		return fmt.Errorf(
+			"Attempted cipher preference check after TLS handshake with remote host %s",
+			c.RemoteAddr().String())
+	}
+	suites := cipherPreferenceProbeSuites
+	if len(c.CipherSuites) >= 2 {
+		suites = c.CipherSuites[:2]
+	}
+	cp := new(CipherPreferenceLog)
+	c.grabData.CipherPreference = cp
+	for _, offer := range [][]uint16{{suites[0], suites[1]}, {suites[1], suites[0]}} {
+		probe := CipherPreferenceProbe{
+			Offered: []tls.CipherSuite{tls.CipherSuite(offer[0]), tls.CipherSuite(offer[1])},
+		}
+		serverHello, err := c.probeServerHello(func(tlsConfig *tls.Config) {
+			tlsConfig.CipherSuites = offer
+			tlsConfig.ForceSuites = true
+		})
+		if serverHello != nil {
+			probe.Selected = &serverHello.CipherSuite
+		}
+		cp.Probes = append(cp.Probes, probe)
+		if serverHello == nil {
+			if err != nil {
+				cp.Error = err.Error()
+			} else {
+				cp.Error = "no ServerHello received"
+			}
+			return nil
+		}
+	}
+	cp.ServerHasCipherPreference = *cp.Probes[0].Selected == *cp.Probes[1].Selected
+	return nil
+}
+
+// CheckClientHelloPadding offers a ClientHello without and then with the
+// padding extension, each over a new connection to the same remote host,
+// and records whether the server answered each.
+func (c *Conn) CheckClientHelloPadding() error {
+	if c.isTls {
+		return fmt.Errorf(
+			"Attempted ClientHello padding check after TLS handshake with remote host %s",
+			c.RemoteAddr().String())
+	}
+	cp := new(ClientHelloPaddingLog)
+	c.grabData.ClientHelloPadding = cp
+	cp.Unpadded = c.probePadding(false)
+	cp.Padded = c.probePadding(true)
+	cp.Changed = cp.Unpadded.Success != cp.Padded.Success
+	return nil
+}
+
+func (c *Conn) probePadding(padding bool) PaddingProbe {
+	var probe PaddingProbe
+	hl, err := c.probeHandshake(func(tlsConfig *tls.Config) {
+		tlsConfig.ClientHelloPadding = padding
+	})
+	if hl != nil {
+		probe.Success = hl.ServerHello != nil
+		if hl.ClientHello != nil {
+			probe.PaddingLength = hl.ClientHello.PaddingLength
+		}
+	}
+	if err != nil {
+		probe.Error = err.Error()
+	}
+	return probe
+}
+
+// FullTLSProfile finds the versions and cipher suites the server accepts,
+// whether it enforces its own suite order, and its key exchange parameters,
+// each handshake over a new connection to the same remote host. For each
+// version the server's choice is removed from the offered suites until it
+// refuses the rest. At most maxHandshakes handshakes are made.
+func (c *Conn) FullTLSProfile(maxHandshakes int) error {
+	if c.isTls {
+		return fmt.Errorf(
+			"Attempted TLS profile after TLS handshake with remote host %s",
+			c.RemoteAddr().String())
+	}
+	p := new(TLSProfile)
+	c.grabData.TLSProfile = p
+	handshake := func(vers uint16, suites []uint16) *tls.ServerHello {
+		if p.Handshakes >= maxHandshakes {
+			p.Truncated = true
+			return nil
+		}
+		p.Handshakes++
+		hl, _ := c.probeHandshake(func(tlsConfig *tls.Config) {
+			tlsConfig.MaxVersion = vers
+			tlsConfig.CipherSuites = suites
+			tlsConfig.ForceSuites = true
+			tlsConfig.CertsOnly = false
+		})
+		if hl == nil || hl.ServerHello == nil || uint16(hl.ServerHello.Version) != vers {
+			return nil
+		}
+		p.addKeyExchange(hl.ServerKeyExchange)
+		return hl.ServerHello
+	}
+
+	for _, vers := range profileVersions {
+		vp := TLSVersionProfile{Version: tls.TLSVersion(vers)}
+		offered := append([]uint16(nil), profileCipherSuites...)
+		var accepted []uint16
+		for len(offered) > 0 {
+			serverHello := handshake(vers, offered)
+			if serverHello == nil {
+				break
+			}
+			selected := uint16(serverHello.CipherSuite)
+			if !removeSuite(&offered, selected) {
+				// The server picked a suite we did not offer
+				break
+			}
+			accepted = append(accepted, selected)
+		}
+		if len(accepted) >= 2 {
+			reversed := make([]uint16, len(accepted))
+			for i, suite := range accepted {
+				reversed[len(accepted)-1-i] = suite
+			}
+			if serverHello := handshake(vers, reversed); serverHello != nil {
+				vp.ServerCipherPreference = uint16(serverHello.CipherSuite) == accepted[0]
+			}
+		}
+		vp.Supported = len(accepted) > 0
+		for _, suite := range accepted {
+			cs := tls.CipherSuite(suite)
+			vp.CipherSuites = append(vp.CipherSuites, cs)
+			if class := cs.Classify(); class != nil && class.ForwardSecret {
+				p.ForwardSecrecy = true
+			}
+		}
+		p.Versions = append(p.Versions, vp)
+	}
+	return nil
+}
+
+// removeSuite removes suite from suites, reporting whether it was there
+func removeSuite(suites *[]uint16, suite uint16) bool {
+	for i, s := range *suites {
+		if s == suite {
+			*suites = append((*suites)[:i], (*suites)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTicketKeyReuse makes n full handshakes, each over a new connection to
+// the same remote host, collecting the session ticket from each. It then
+// tries to resume the first session on one more connection. A server, or a
+// set of servers behind a load balancer, that never rotates its ticket key
+// gives tickets with one key name and accepts the first one back.
+func (c *Conn) CheckTicketKeyReuse(n int) error {
+	if c.isTls {
+		return fmt.Errorf(
+			"Attempted ticket key reuse check after TLS handshake with remote host %s",
+			c.RemoteAddr().String())
+	}
+	e := new(TicketKeyReuseLog)
+	c.grabData.TicketKeyReuse = e
+	cache := new(firstSessionCache)
+	for i := 0; i < n; i++ {
+		hl, _, err := c.probeSessionTicket(cache)
+		if err != nil {
+			e.Error = err.Error()
+			break
+		}
+		if hl.SessionTicket == nil {
+			e.Error = "no session ticket received"
+			break
+		}
+		e.addTicket(hl.SessionTicket.Value)
+	}
+	if cache.session != nil && e.Error == "" {
+		cache.resume = true
+		_, resumed, err := c.probeSessionTicket(cache)
+		if err != nil {
+			e.Error = err.Error()
+		}
+		e.Resumed = resumed
+	}
+	e.Estimate = e.estimate()
+	return nil
+}
+
+// probeSessionTicket dials the remote host again, see dialAgain, and
+// completes a handshake with c's TLS configuration using cache for session
+// tickets. It returns the handshake log and whether the session was resumed.
+func (c *Conn) probeSessionTicket(cache tls.ClientSessionCache) (*tls.ServerHandshake, bool, error) {
+	conn, err := c.dialAgain(c.RemoteAddr(), c.readDeadline)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	tlsConfig := c.buildTLSConfig()
+	tlsConfig.CertsOnly = false
+	tlsConfig.ClientSessionCache = cache
+	tlsConn := tls.Client(conn, tlsConfig)
+	tlsConn.SetReadDeadline(c.readDeadline)
+	tlsConn.SetWriteDeadline(c.writeDeadline)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, false, err
+	}
+	return tlsConn.GetHandshakeLog(), tlsConn.ConnectionState().DidResume, nil
+}
+
+// probeServerHello dials the remote host again, see dialAgain, and starts a
+// handshake with c's TLS configuration, adjusted by configure, stopping once
+// the server's certificates arrive. It returns the ServerHello if one was
+// received.
+func (c *Conn) probeServerHello(configure func(*tls.Config)) (*tls.ServerHello, error) {
+	hl, err := c.probeHandshake(configure)
+	if hl == nil {
+		return nil, err
+	}
+	return hl.ServerHello, err
+}
+
+// probeHandshake is like probeServerHello but returns the whole handshake
+// log, which is nil if no ClientHello was sent. configure may clear
+// CertsOnly to complete the handshake.
+func (c *Conn) probeHandshake(configure func(*tls.Config)) (*tls.ServerHandshake, error) {
+	conn, err := c.dialAgain(c.RemoteAddr(), c.readDeadline)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	tlsConfig := c.buildTLSConfig()
+	tlsConfig.CertsOnly = true
+	configure(tlsConfig)
+	tlsConn := tls.Client(conn, tlsConfig)
+	tlsConn.SetReadDeadline(c.readDeadline)
+	tlsConn.SetWriteDeadline(c.writeDeadline)
+	err = tlsConn.Handshake()
+	if err == tls.ErrCertsOnly {
+		err = nil
+	}
+	return tlsConn.GetHandshakeLog(), err
+}
+
 func (c *Conn) BACNetVendorQuery() error {
 	c.grabData.BACNet = new(bacnet.Log)
 	if err := c.grabData.BACNet.QueryDeviceID(c.getUnderlyingConn()); err != nil {
@@ -598,3 +2336,12 @@ func (c *Conn) GetFTPSCertificates() error {
 		return nil
 	}
 }
+
+// FTPDataProtection sends PBSZ 0 and PROT P after GetFTPSCertificates to
+// find out whether the server would also encrypt data connections
+func (c *Conn) FTPDataProtection() error {
+	if !c.isTls {
+		return errors.New("FTP data protection requires a TLS control channel")
+	}
+	return ftp.SetupDataProtection(c.grabData.FTP, c.getUnderlyingConn())
+}
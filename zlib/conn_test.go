@@ -0,0 +1,2321 @@
+package zlib_test
+
+import (
+	"bufio"
+	"bytes"
+	stdzlib "compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmap/zcrypto/ct"
+	jsonKeys "github.com/zmap/zcrypto/json"
+	ztls "github.com/zmap/zcrypto/tls"
+	zx509 "github.com/zmap/zcrypto/x509"
+	"github.com/zmap/zgrab/zlib"
+	"github.com/zmap/zgrab/ztools/ftp"
+)
+
+func TestNewConnSMTP(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		server.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		line, err := bufio.NewReader(server).ReadString('\n')
+		if err != nil || line != "EHLO zgrab.local\r\n" {
+			t.Errorf("Wrong EHLO - expected: %q, got: %q (%v)", "EHLO zgrab.local\r\n", line, err)
+			return
+		}
+		server.Write([]byte("250-mail.example.com\r\n250 STARTTLS\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	banner := make([]byte, 1024)
+	n, err := c.SMTPBanner(banner)
+	if err != nil {
+		t.Fatalf("SMTPBanner failed: %s", err)
+	}
+	if got := string(banner[0:n]); got != "220 mail.example.com ESMTP\r\n" {
+		t.Errorf("Wrong banner: %q", got)
+	}
+	if err := c.EHLO("zgrab.local"); err != nil {
+		t.Errorf("EHLO failed: %s", err)
+	}
+
+	data := c.GrabData()
+	if data.Banner != "220 mail.example.com ESMTP\r\n" {
+		t.Errorf("Wrong recorded banner: %q", data.Banner)
+	}
+	if data.EHLO != "250-mail.example.com\r\n250 STARTTLS\r\n" {
+		t.Errorf("Wrong recorded EHLO: %q", data.EHLO)
+	}
+}
+
+func TestSMTPGreetingTiming(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		server.Write([]byte("220-mail.example.com\r\n"))
+		time.Sleep(1100 * time.Millisecond)
+		server.Write([]byte("220-Please wait\r\n220 ESMTP\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetSMTPGreetingWait(2 * time.Second)
+	if _, err := c.SMTPBanner(make([]byte, 1024)); err != nil {
+		t.Fatalf("SMTPBanner failed: %s", err)
+	}
+	g := c.GrabData().SMTPGreeting
+	if g.Lines != 3 || len(g.LineDelaysMicros) != 3 {
+		t.Fatalf("Wrong line count: %+v", g)
+	}
+	if !g.Tarpit || g.LineDelaysMicros[1] < 1000000 || g.LineDelaysMicros[2] != 0 {
+		t.Errorf("Greeting delay not recorded: %+v", g)
+	}
+	if g.TotalMicros < g.LineDelaysMicros[1] {
+		t.Errorf("Total time shorter than a line delay: %+v", g)
+	}
+}
+
+func TestEHLOFallbackToHELO(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		if line, _ := r.ReadString('\n'); line != "EHLO zgrab.local\r\n" {
+			t.Errorf("Wrong command: %q", line)
+			return
+		}
+		server.Write([]byte("502 Command not implemented\r\n"))
+		if line, _ := r.ReadString('\n'); line != "HELO zgrab.local\r\n" {
+			t.Errorf("No HELO fallback: %q", line)
+			return
+		}
+		server.Write([]byte("250 mail.example.com\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.EHLO("zgrab.local"); err != nil {
+		t.Fatalf("EHLO failed: %s", err)
+	}
+	data := c.GrabData()
+	if data.SMTPHello != "HELO" {
+		t.Errorf("Wrong accepted greeting - expected: HELO, got: %q", data.SMTPHello)
+	}
+	if data.EHLO != "502 Command not implemented\r\n" || data.HELO != "250 mail.example.com\r\n" {
+		t.Errorf("Wrong recorded responses: EHLO %q, HELO %q", data.EHLO, data.HELO)
+	}
+}
+
+func TestBannerReadTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go server.Write([]byte("220 mail.exam"))
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := c.SMTPBanner(make([]byte, 1024))
+	timeoutErr, ok := err.(*zlib.ReadTimeoutError)
+	if !ok {
+		t.Fatalf("Expected a read timeout, got: %v", err)
+	}
+	if timeoutErr.Read != 13 {
+		t.Errorf("Wrong partial read count: %d", timeoutErr.Read)
+	}
+	if banner := c.GrabData().Banner; banner != "220 mail.exam" {
+		t.Errorf("Partial banner not kept: %q", banner)
+	}
+}
+
+func TestHalfClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		server, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		request, _ := ioutil.ReadAll(server)
+		server.Write(append([]byte("got "), request...))
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := zlib.NewConn(conn)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second))
+	c.Write([]byte("ping"))
+	if _, err := c.HalfClose(make([]byte, 1024)); err != nil {
+		t.Fatalf("HalfClose failed: %s", err)
+	}
+	log := c.GrabData().HalfClose
+	if log == nil || log.Outcome != zlib.HalfCloseOutcomeClosed || log.Response != "got ping" {
+		t.Errorf("Wrong half-close log: %+v", log)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if err := zlib.NewConn(client).CloseWrite(); err == nil {
+		t.Error("CloseWrite succeeded on a non-TCP connection")
+	}
+}
+
+func TestImplicitTLSSMTP(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		tlsServer := tls.Server(server, ts.TLS.Clone())
+		defer tlsServer.Close()
+		tlsServer.Write([]byte("220 mail.example.com ESMTP\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetImplicitTLS(true)
+	banner := make([]byte, 1024)
+	if _, err := c.SMTPBanner(banner); err != nil {
+		t.Fatalf("SMTPBanner failed: %s", err)
+	}
+
+	data := c.GrabData()
+	if !data.IsTLS || data.TLSHandshake == nil {
+		t.Errorf("Banner was not read over TLS")
+	}
+	if data.Banner != "220 mail.example.com ESMTP\r\n" {
+		t.Errorf("Wrong recorded banner: %q", data.Banner)
+	}
+}
+
+func TestIMAPCapabilityAfterStartTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		if line, _ := r.ReadString('\n'); line != "a002 CAPABILITY\r\n" {
+			t.Errorf("Wrong command: %q", line)
+			return
+		}
+		server.Write([]byte("* CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED\r\na002 OK done\r\n"))
+		if line, _ := r.ReadString('\n'); line != "a001 STARTTLS\r\n" {
+			t.Errorf("Wrong command: %q", line)
+			return
+		}
+		server.Write([]byte("a001 OK Begin TLS negotiation now\r\n"))
+		tlsServer := tls.Server(server, ts.TLS.Clone())
+		defer tlsServer.Close()
+		r = bufio.NewReader(tlsServer)
+		if line, _ := r.ReadString('\n'); line != "a002 CAPABILITY\r\n" {
+			t.Errorf("Wrong command after STARTTLS: %q", line)
+			return
+		}
+		tlsServer.Write([]byte("* CAPABILITY IMAP4rev1 AUTH=PLAIN\r\na002 OK done\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.IMAPCapability(); err != nil {
+		t.Fatalf("IMAPCapability failed: %s", err)
+	}
+	if err := c.IMAPStartTLSHandshake(); err != nil {
+		t.Fatalf("IMAPStartTLSHandshake failed: %s", err)
+	}
+	if err := c.IMAPCapability(); err != nil {
+		t.Fatalf("IMAPCapability after STARTTLS failed: %s", err)
+	}
+
+	e := c.GrabData().IMAPCapability
+	if want := []string{"IMAP4REV1", "STARTTLS", "LOGINDISABLED"}; !reflect.DeepEqual(e.PreTLS, want) {
+		t.Errorf("Wrong pre-TLS capabilities: %v", e.PreTLS)
+	}
+	if want := []string{"AUTH=PLAIN"}; !reflect.DeepEqual(e.Added, want) {
+		t.Errorf("Wrong added capabilities: %v", e.Added)
+	}
+	if want := []string{"STARTTLS", "LOGINDISABLED"}; !reflect.DeepEqual(e.Removed, want) {
+		t.Errorf("Wrong removed capabilities: %v", e.Removed)
+	}
+}
+
+func TestSMTPStartTLSPartialResponse(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		bufio.NewReader(server).ReadString('\n')
+		server.Write([]byte("45"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	err := c.SMTPStartTLSHandshake()
+	if err != io.EOF {
+		t.Errorf("Expected the read error to be kept, got: %v", err)
+	}
+	if got := c.GrabData().StartTLS; got != "45" {
+		t.Errorf("Partial response not kept: %q", got)
+	}
+}
+
+func TestSMTPStartTLSInjectionTest(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		if line, _ := r.ReadString('\n'); line != "STARTTLS\r\n" {
+			t.Errorf("Wrong command: %q", line)
+			return
+		}
+		if line, _ := r.ReadString('\n'); line != "NOOP\r\n" {
+			t.Errorf("Wrong injected command: %q", line)
+			return
+		}
+		server.Write([]byte("220 Ready to start TLS\r\n"))
+		// A vulnerable server answers the buffered NOOP after the handshake
+		tlsServer := tls.Server(server, ts.TLS.Clone())
+		defer tlsServer.Close()
+		if err := tlsServer.Handshake(); err != nil {
+			t.Errorf("Handshake failed: %s", err)
+			return
+		}
+		tlsServer.Write([]byte("250 OK\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.SMTPStartTLSInjectionTest(); err != nil {
+		t.Fatalf("SMTPStartTLSInjectionTest failed: %s", err)
+	}
+	e := c.GrabData().StartTLSInjection
+	if !e.Vulnerable || e.Response != "250 OK\r\n" {
+		t.Errorf("Injected command not detected: %+v", e)
+	}
+}
+
+func TestSMTPPipelinedStartTLSHandshake(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		if line, _ := r.ReadString('\n'); line != "EHLO example.com\r\n" {
+			t.Errorf("Wrong command: %q", line)
+			return
+		}
+		if line, _ := r.ReadString('\n'); line != "STARTTLS\r\n" {
+			t.Errorf("STARTTLS was not pipelined: %q", line)
+			return
+		}
+		server.Write([]byte("250-mx.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n220 Ready to start TLS\r\n"))
+		tlsServer := tls.Server(server, ts.TLS.Clone())
+		defer tlsServer.Close()
+		if err := tlsServer.Handshake(); err != nil {
+			t.Errorf("Handshake failed: %s", err)
+		}
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	c.SetSMTPPipeliningKnown()
+	if err := c.SMTPPipelinedStartTLSHandshake("example.com"); err != nil {
+		t.Fatalf("SMTPPipelinedStartTLSHandshake failed: %s", err)
+	}
+	g := c.GrabData()
+	if !g.SMTPPipelined || !g.IsTLS {
+		t.Errorf("Pipelined handshake not recorded: pipelined %v, tls %v", g.SMTPPipelined, g.IsTLS)
+	}
+	if g.EHLO != "250-mx.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n" {
+		t.Errorf("Wrong EHLO response: %q", g.EHLO)
+	}
+	if g.StartTLS != "220 Ready to start TLS\r\n" {
+		t.Errorf("Wrong STARTTLS response: %q", g.StartTLS)
+	}
+}
+
+func TestSMTPPipelinedStartTLSHandshakeUnknown(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		if line, _ := r.ReadString('\n'); line != "EHLO example.com\r\n" {
+			t.Errorf("Wrong command: %q", line)
+			return
+		}
+		// STARTTLS must wait for the EHLO reply
+		server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if line, _ := r.ReadString('\n'); line != "" {
+			t.Errorf("STARTTLS was sent before the EHLO reply: %q", line)
+			return
+		}
+		server.SetReadDeadline(time.Time{})
+		server.Write([]byte("250-mx.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n"))
+		if line, _ := r.ReadString('\n'); line != "STARTTLS\r\n" {
+			t.Errorf("Wrong command: %q", line)
+			return
+		}
+		server.Write([]byte("220 Ready to start TLS\r\n"))
+		tlsServer := tls.Server(server, ts.TLS.Clone())
+		defer tlsServer.Close()
+		if err := tlsServer.Handshake(); err != nil {
+			t.Errorf("Handshake failed: %s", err)
+		}
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.SMTPPipelinedStartTLSHandshake("example.com"); err != nil {
+		t.Fatalf("SMTPPipelinedStartTLSHandshake failed: %s", err)
+	}
+	g := c.GrabData()
+	if g.SMTPPipelined || !g.IsTLS {
+		t.Errorf("Sequential handshake misrecorded: pipelined %v, tls %v", g.SMTPPipelined, g.IsTLS)
+	}
+	if g.StartTLS != "220 Ready to start TLS\r\n" {
+		t.Errorf("Wrong STARTTLS response: %q", g.StartTLS)
+	}
+}
+
+func TestSMTPPipelinedStartTLSHandshakeNotAdvertised(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		r.ReadString('\n')
+		r.ReadString('\n')
+		server.Write([]byte("250-mx.example.com\r\n250 STARTTLS\r\n220 Ready to start TLS\r\n"))
+		tlsServer := tls.Server(server, ts.TLS.Clone())
+		defer tlsServer.Close()
+		if err := tlsServer.Handshake(); err != nil {
+			t.Errorf("Handshake failed: %s", err)
+		}
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	c.SetSMTPPipeliningKnown()
+	if err := c.SMTPPipelinedStartTLSHandshake("example.com"); err != nil {
+		t.Fatalf("SMTPPipelinedStartTLSHandshake failed: %s", err)
+	}
+	if g := c.GrabData(); g.SMTPPipelined || !g.IsTLS {
+		t.Errorf("Pipelining recorded without PIPELINING: pipelined %v, tls %v", g.SMTPPipelined, g.IsTLS)
+	}
+}
+
+func TestConnectOnly(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	defer ln.Close()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	c.ConnectOnly()
+
+	e := c.GrabData().Connect
+	if e == nil || !e.ConnectOnly {
+		t.Fatalf("Connection was not recorded: %+v", e)
+	}
+	if e.RemoteAddr != ln.Addr().String() {
+		t.Errorf("Wrong remote address - expected: %s, got: %s", ln.Addr(), e.RemoteAddr)
+	}
+	if e.ConnectTimeMicros < 0 {
+		t.Errorf("Negative connect time: %d", e.ConnectTimeMicros)
+	}
+}
+
+func TestHTTPMultiReconnects(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		// The first connection closes after the second response, so the
+		// third request must be sent again on a new connection.
+		batches := []struct {
+			requests  int
+			responses []string
+		}{
+			{3, []string{
+				"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\none",
+				"HTTP/1.1 404 Not Found\r\nConnection: close\r\nContent-Length: 3\r\n\r\ntwo",
+			}},
+			{1, []string{
+				"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nthree",
+			}},
+		}
+		for _, batch := range batches {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			for i := 0; i < batch.requests; i++ {
+				if _, err := http.ReadRequest(r); err != nil {
+					t.Errorf("ReadRequest failed: %s", err)
+					conn.Close()
+					return
+				}
+			}
+			for _, res := range batch.responses {
+				conn.Write([]byte(res))
+			}
+			conn.Close()
+		}
+	}()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	var configs []*zlib.HTTPConfig
+	for _, endpoint := range []string{"/", "/robots.txt", "/.well-known/security.txt"} {
+		configs = append(configs, &zlib.HTTPConfig{Method: "GET", Endpoint: endpoint, MaxSize: 256})
+	}
+	if err := c.HTTPMulti(configs); err != nil {
+		t.Fatalf("HTTPMulti failed: %s", err)
+	}
+
+	exchanges := c.GrabData().HTTP.Pipelined
+	if len(exchanges) != 3 {
+		t.Fatalf("Wrong number of exchanges - expected: 3, got: %d", len(exchanges))
+	}
+	want := []struct {
+		endpoint    string
+		status      int
+		body        string
+		reconnected bool
+	}{
+		{"/", 200, "one", false},
+		{"/robots.txt", 404, "two", false},
+		{"/.well-known/security.txt", 200, "three", true},
+	}
+	for i, w := range want {
+		e := exchanges[i]
+		if e.Request.Endpoint != w.endpoint || e.Response.StatusCode != w.status ||
+			e.Response.Body != w.body || e.Reconnected != w.reconnected {
+			t.Errorf("Wrong exchange %d: %+v %+v (reconnected: %v)", i, e.Request, e.Response, e.Reconnected)
+		}
+	}
+}
+
+func TestHTTPMultiAbandonsLargeBody(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		// The first body is more than twice MaxSize, too large to drain
+		// before the second response
+		batches := []struct {
+			requests  int
+			responses []string
+		}{
+			{2, []string{
+				"HTTP/1.1 200 OK\r\nContent-Length: 4096\r\n\r\n" + strings.Repeat("a", 4096),
+				"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nold",
+			}},
+			{1, []string{
+				"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nnew",
+			}},
+		}
+		for _, batch := range batches {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			for i := 0; i < batch.requests; i++ {
+				if _, err := http.ReadRequest(r); err != nil {
+					t.Errorf("ReadRequest failed: %s", err)
+					conn.Close()
+					return
+				}
+			}
+			for _, res := range batch.responses {
+				conn.Write([]byte(res))
+			}
+			defer conn.Close()
+		}
+	}()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	configs := []*zlib.HTTPConfig{
+		{Method: "GET", Endpoint: "/", MaxSize: 1},
+		{Method: "GET", Endpoint: "/next", MaxSize: 1},
+	}
+	if err := c.HTTPMulti(configs); err != nil {
+		t.Fatalf("HTTPMulti failed: %s", err)
+	}
+
+	exchanges := c.GrabData().HTTP.Pipelined
+	if len(exchanges) != 2 {
+		t.Fatalf("Wrong number of exchanges - expected: 2, got: %d", len(exchanges))
+	}
+	if res := exchanges[0].Response; len(res.Body) != 1024 {
+		t.Errorf("Wrong first body length - expected: 1024, got: %d", len(res.Body))
+	}
+	if e := exchanges[1]; !e.Reconnected || e.Response.Body != "new" {
+		t.Errorf("Second request not sent again: %+v (reconnected: %v)", e.Response, e.Reconnected)
+	}
+}
+
+func TestHTTPBodyFraming(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		for i := 0; i < 2; i++ {
+			if _, err := http.ReadRequest(r); err != nil {
+				t.Errorf("ReadRequest failed: %s", err)
+				return
+			}
+		}
+		server.Write([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\none\r\n0\r\n\r\n"))
+		// Closes after 4 of the promised 10 bytes
+		server.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nfour"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	configs := []*zlib.HTTPConfig{
+		{Method: "GET", Endpoint: "/", MaxSize: 256},
+		{Method: "GET", Endpoint: "/short", MaxSize: 256},
+	}
+	if err := c.HTTPMulti(configs); err != nil {
+		t.Fatalf("HTTPMulti failed: %s", err)
+	}
+
+	exchanges := c.GrabData().HTTP.Pipelined
+	if len(exchanges) != 2 {
+		t.Fatalf("Wrong number of exchanges - expected: 2, got: %d", len(exchanges))
+	}
+	if res := exchanges[0].Response; res.BodyFraming != zlib.HTTPFramingChunked || res.Body != "one" || res.BodyTruncated {
+		t.Errorf("Wrong chunked response: %+v", res)
+	}
+	if res := exchanges[1].Response; res.BodyFraming != zlib.HTTPFramingContentLength || res.Body != "four" || !res.BodyTruncated {
+		t.Errorf("Wrong short response: %+v", res)
+	}
+}
+
+func TestHTTPDecodeCharset(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		for i := 0; i < 4; i++ {
+			if _, err := http.ReadRequest(r); err != nil {
+				t.Errorf("ReadRequest failed: %s", err)
+				return
+			}
+		}
+		server.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/html; charset=windows-1252\r\nContent-Length: 2\r\n\r\n\x80!"))
+		server.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/html; charset=Shift_JIS\r\nContent-Length: 9\r\n\r\n\x82\xa0\xb1\x88\x9f\xfd\x81\x7e\x5c"))
+		server.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 38\r\n\r\n<html><meta charset=\"sjis\"><p>\x93\xfa\x96\x7b</p>"))
+		server.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/html; charset=EUC-JP\r\nContent-Length: 2\r\n\r\n\xa4\xa2"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	configs := []*zlib.HTTPConfig{
+		{Method: "GET", Endpoint: "/", MaxSize: 256, DecodeCharset: true},
+		{Method: "GET", Endpoint: "/sjis", MaxSize: 256, DecodeCharset: true},
+		{Method: "GET", Endpoint: "/meta", MaxSize: 256, DecodeCharset: true},
+		{Method: "GET", Endpoint: "/euc", MaxSize: 256, DecodeCharset: true},
+	}
+	if err := c.HTTPMulti(configs); err != nil {
+		t.Fatalf("HTTPMulti failed: %s", err)
+	}
+
+	exchanges := c.GrabData().HTTP.Pipelined
+	if res := exchanges[0].Response; res.BodyUTF8 != "\u20ac!" || res.BodyCharsetUnsupported != "" {
+		t.Errorf("Wrong windows-1252 decoding: %q, unsupported %q", res.BodyUTF8, res.BodyCharsetUnsupported)
+	}
+	// Half-width katakana are single bytes, and 0xFD is not a lead byte
+	if res := exchanges[1].Response; res.BodyUTF8 != "\u3042\uff71\u4e9c\ufffd\u00d7\\" || res.BodyCharsetUnsupported != "" {
+		t.Errorf("Wrong Shift_JIS decoding: %q, unsupported %q", res.BodyUTF8, res.BodyCharsetUnsupported)
+	}
+	if res := exchanges[2].Response; res.BodyUTF8 != "<html><meta charset=\"sjis\"><p>\u65e5\u672c</p>" {
+		t.Errorf("Wrong decoding of <meta> charset: %q", res.BodyUTF8)
+	}
+	if res := exchanges[3].Response; res.BodyUTF8 != "" || res.BodyCharsetUnsupported != "euc-jp" {
+		t.Errorf("EUC-JP not recorded as unsupported: %q, unsupported %q", res.BodyUTF8, res.BodyCharsetUnsupported)
+	}
+}
+
+func TestHTTPInterimResponses(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(server)); err != nil {
+			t.Errorf("ReadRequest failed: %s", err)
+			return
+		}
+		server.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n" +
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	configs := []*zlib.HTTPConfig{{Method: "GET", Endpoint: "/", MaxSize: 256, RawHeaders: true}}
+	if err := c.HTTPMulti(configs); err != nil {
+		t.Fatalf("HTTPMulti failed: %s", err)
+	}
+
+	res := c.GrabData().HTTP.Pipelined[0].Response
+	if res.StatusCode != 200 || res.Body != "ok" {
+		t.Errorf("Wrong final response: %+v", res)
+	}
+	if len(res.InterimResponses) != 2 {
+		t.Fatalf("Wrong number of interim responses - expected: 2, got: %d", len(res.InterimResponses))
+	}
+	if code := res.InterimResponses[0].StatusCode; code != 100 {
+		t.Errorf("Wrong first interim status - expected: 100, got: %d", code)
+	}
+	if raw := res.InterimResponses[1].RawHeaders; raw != "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" {
+		t.Errorf("Wrong second interim headers: %q", raw)
+	}
+}
+
+func TestHTTPSmugglingIndicators(t *testing.T) {
+	tests := []struct {
+		response string
+		want     []string
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", nil},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 2\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n",
+			[]string{zlib.HTTPSmugglingContentLengthAndTransferEncoding}},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Length: 3\r\n\r\nok!",
+			[]string{zlib.HTTPSmugglingConflictingContentLength}},
+	}
+	for _, test := range tests {
+		client, server := net.Pipe()
+		go func(response string) {
+			defer server.Close()
+			if _, err := http.ReadRequest(bufio.NewReader(server)); err != nil {
+				return
+			}
+			server.Write([]byte(response))
+		}(test.response)
+
+		c := zlib.NewConn(client)
+		c.SetDeadline(time.Now().Add(3 * time.Second))
+		// net/http rejects conflicting lengths, so only the indicators are kept
+		c.HTTPMulti([]*zlib.HTTPConfig{{Method: "GET", Endpoint: "/", MaxSize: 256}})
+		client.Close()
+
+		var got []string
+		if p := c.GrabData().HTTP.Pipelined; len(p) > 0 && p[0].Response != nil {
+			got = p[0].Response.SmugglingIndicators
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Wrong indicators for %q - expected: %v, got: %v", test.response, test.want, got)
+		}
+	}
+}
+
+func TestProbeWellKnownPaths(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.git/HEAD":
+			w.Write([]byte("ref: refs/heads/master\n"))
+		case "/robots.txt":
+			w.Write([]byte("<html>Welcome!</html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.ProbeWellKnownPaths(&zlib.HTTPConfig{Method: "GET", MaxSize: 256}); err != nil {
+		t.Fatalf("ProbeWellKnownPaths failed: %s", err)
+	}
+
+	data := c.GrabData()
+	if n := len(data.HTTP.Pipelined); n != len(zlib.DefaultWellKnownPaths) {
+		t.Errorf("Wrong number of responses - expected: %d, got: %d", len(zlib.DefaultWellKnownPaths), n)
+	}
+	want := []zlib.WellKnownFinding{{Path: "/.git/HEAD", Finding: zlib.WellKnownExposedGitHead}}
+	if !reflect.DeepEqual(data.WellKnown.Findings, want) {
+		t.Errorf("Wrong findings:\n got: %+v\nwant: %+v", data.WellKnown.Findings, want)
+	}
+}
+
+func TestAutoProbeIMAP(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		server.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+		line, err := bufio.NewReader(server).ReadString('\n')
+		if err != nil || line != "a002 CAPABILITY\r\n" {
+			t.Errorf("Wrong command - expected: %q, got: %q (%v)", "a002 CAPABILITY\r\n", line, err)
+			return
+		}
+		server.Write([]byte("* CAPABILITY IMAP4rev1 STARTTLS\r\na002 OK done\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.AutoProbe(); err != nil {
+		t.Fatalf("AutoProbe failed: %s", err)
+	}
+
+	data := c.GrabData()
+	if data.AutoProbe.Service != zlib.ServiceIMAP {
+		t.Errorf("Wrong service - expected: %s, got: %s", zlib.ServiceIMAP, data.AutoProbe.Service)
+	}
+	if data.IMAPCapability == nil || len(data.IMAPCapability.PreTLS) != 2 {
+		t.Errorf("Capabilities were not requested: %+v", data.IMAPCapability)
+	}
+}
+
+func TestAutoProbePOP3(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		server.Write([]byte("+OK POP3 ready\r\n"))
+		line, err := bufio.NewReader(server).ReadString('\n')
+		if err != nil || line != "STLS\r\n" {
+			t.Errorf("Wrong command - expected: %q, got: %q (%v)", "STLS\r\n", line, err)
+			return
+		}
+		server.Write([]byte("-ERR not supported\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.AutoProbe(); err == nil {
+		t.Errorf("AutoProbe succeeded although STLS was refused")
+	}
+
+	data := c.GrabData()
+	if data.AutoProbe.Service != zlib.ServicePOP3 {
+		t.Errorf("Wrong service - expected: %s, got: %s", zlib.ServicePOP3, data.AutoProbe.Service)
+	}
+	if data.StartTLS != "-ERR not supported\r\n" {
+		t.Errorf("STLS reply not recorded: %q", data.StartTLS)
+	}
+}
+
+func TestAutoProbeSilentHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.AutoProbe(); err != nil {
+		t.Fatalf("AutoProbe failed: %s", err)
+	}
+
+	e := c.GrabData().AutoProbe
+	if e.Service != zlib.ServiceHTTP {
+		t.Errorf("Wrong service - expected: %s, got: %s", zlib.ServiceHTTP, e.Service)
+	}
+	if e.HTTP == nil || e.HTTP.Response.Body != "hello" {
+		t.Fatalf("HTTP response was not recorded: %+v", e.HTTP)
+	}
+	if ttfb := e.HTTP.Response.TimeToFirstByteMicros; ttfb < 50000 {
+		t.Errorf("Time to first byte does not include the server delay: %dus", ttfb)
+	}
+}
+
+func TestReadProactiveBanner(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetExpectProactiveBanner(50 * time.Millisecond)
+	if err := c.ReadProactiveBanner(); err != nil {
+		t.Fatalf("ReadProactiveBanner failed on a silent server: %s", err)
+	}
+	if banner := c.GrabData().Banner; banner != "" {
+		t.Errorf("Unexpected banner from a silent server: %q", banner)
+	}
+
+	// The connection must still be usable after the short wait
+	go server.Write([]byte("220 mail.example.com ESMTP\r\n"))
+	c.SetExpectProactiveBanner(time.Second)
+	if err := c.ReadProactiveBanner(); err != nil {
+		t.Fatalf("ReadProactiveBanner failed: %s", err)
+	}
+	if banner := c.GrabData().Banner; banner != "220 mail.example.com ESMTP\r\n" {
+		t.Errorf("Wrong banner: %q", banner)
+	}
+}
+
+func TestClientHelloRecordVersion(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	header := make(chan []byte, 1)
+	go func() {
+		defer server.Close()
+		b := make([]byte, 5)
+		if _, err := io.ReadFull(server, b); err != nil {
+			t.Errorf("Reading record header failed: %s", err)
+		}
+		header <- b
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetClientHelloRecordVersion(0x0303)
+	c.TLSHandshake()
+
+	b := <-header
+	if b[0] != 0x16 || b[1] != 0x03 || b[2] != 0x03 {
+		t.Errorf("Wrong ClientHello record header: %x", b)
+	}
+}
+
+func TestDialRecordsResolution(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+
+	dns := c.GrabData().DNS
+	if dns == nil || dns.Name != "localhost" {
+		t.Fatalf("Resolution was not recorded: %+v", dns)
+	}
+	if dns.ResolvedIP != "127.0.0.1" {
+		t.Errorf("Wrong resolved IP - expected: 127.0.0.1, got: %s", dns.ResolvedIP)
+	}
+}
+
+func TestRawBannerCapture(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		server.Write([]byte("220 mail.example.com ESMTP\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetRawBannerCapture(8)
+	banner := make([]byte, 1024)
+	if _, err := c.SMTPBanner(banner); err != nil {
+		t.Fatalf("SMTPBanner failed: %s", err)
+	}
+	if raw := string(c.GrabData().RawBanner); raw != "220 mail" {
+		t.Errorf("Wrong raw banner: %q", raw)
+	}
+}
+
+func TestByteCounters(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		server.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		bufio.NewReader(server).ReadString('\n')
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	banner := make([]byte, 1024)
+	if _, err := c.SMTPBanner(banner); err != nil {
+		t.Fatalf("SMTPBanner failed: %s", err)
+	}
+	if _, err := c.Write([]byte("QUIT\r\n")); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	data := c.GrabData()
+	if data.BytesRead != 28 || data.BytesWritten != 6 {
+		t.Errorf("Wrong byte counts - expected: 28 read, 6 written, got: %d read, %d written", data.BytesRead, data.BytesWritten)
+	}
+}
+
+func TestWriteFragmentation(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	reads := make(chan []int, 1)
+	go func() {
+		defer server.Close()
+		var sizes []int
+		buf := make([]byte, 64)
+		for total := 0; total < 18; {
+			n, err := server.Read(buf)
+			if err != nil {
+				break
+			}
+			sizes = append(sizes, n)
+			total += n
+		}
+		reads <- sizes
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetWriteFragmentSize(5)
+	if n, err := c.Write([]byte("EHLO zgrab.local\r\n")); n != 18 || err != nil {
+		t.Fatalf("Write failed: %d, %v", n, err)
+	}
+	if sizes, want := <-reads, []int{5, 5, 5, 3}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Wrong fragments - expected: %v, got: %v", want, sizes)
+	}
+	if e := c.GrabData().WriteFragmentation; e == nil || e.Size != 5 {
+		t.Errorf("Fragmentation not recorded: %+v", e)
+	}
+}
+
+func TestNewConnWithConfigWrapsConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	reads := make(chan []int, 1)
+	go func() {
+		defer server.Close()
+		server.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		var sizes []int
+		buf := make([]byte, 64)
+		for total := 0; total < 6; {
+			n, err := server.Read(buf)
+			if err != nil {
+				break
+			}
+			sizes = append(sizes, n)
+			total += n
+		}
+		reads <- sizes
+	}()
+
+	c := zlib.NewConnWithConfig(client, zlib.ConnConfig{
+		RawBannerSize:      8,
+		WriteFragmentSize:  4,
+		WriteFragmentDelay: time.Millisecond,
+	})
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	banner := make([]byte, 1024)
+	if _, err := c.SMTPBanner(banner); err != nil {
+		t.Fatalf("SMTPBanner failed: %s", err)
+	}
+	if _, err := c.Write([]byte("QUIT\r\n")); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if raw := string(c.GrabData().RawBanner); raw != "220 mail" {
+		t.Errorf("Wrong raw banner: %q", raw)
+	}
+	if sizes, want := <-reads, []int{4, 2}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Wrong fragments - expected: %v, got: %v", want, sizes)
+	}
+	if e := c.GrabData().WriteFragmentation; e == nil || e.Size != 4 || e.DelayMicros != 1000 {
+		t.Errorf("Fragmentation not recorded: %+v", e)
+	}
+}
+
+func TestFTPDataProtection(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		if line, _ := r.ReadString('\n'); line != "AUTH TLS\r\n" {
+			t.Errorf("Wrong command: %q", line)
+			return
+		}
+		server.Write([]byte("234 AUTH TLS successful\r\n"))
+		tlsServer := tls.Server(server, ts.TLS.Clone())
+		defer tlsServer.Close()
+		r = bufio.NewReader(tlsServer)
+		if line, _ := r.ReadString('\n'); line != "PBSZ 0\r\n" {
+			t.Errorf("Wrong command: %q", line)
+			return
+		}
+		tlsServer.Write([]byte("200 PBSZ=0\r\n"))
+		if line, _ := r.ReadString('\n'); line != "PROT P\r\n" {
+			t.Errorf("Wrong command: %q", line)
+			return
+		}
+		tlsServer.Write([]byte("200 Protection level set to P\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.GrabData().FTP = new(ftp.FTPLog)
+	if err := c.GetFTPSCertificates(); err != nil {
+		t.Fatalf("GetFTPSCertificates failed: %s", err)
+	}
+	if err := c.FTPDataProtection(); err != nil {
+		t.Fatalf("FTPDataProtection failed: %s", err)
+	}
+	if e := c.GrabData().FTP; e.DataProtected == nil || !*e.DataProtected || e.PBSZResp != "200 PBSZ=0\r\n" {
+		t.Errorf("Data protection not recorded: %+v", e)
+	}
+}
+
+func TestWhoisTruncation(t *testing.T) {
+	for _, reply := range []string{"12345678", "123456789"} {
+		client, server := net.Pipe()
+		go func(reply string) {
+			defer server.Close()
+			bufio.NewReader(server).ReadString('\n')
+			server.Write([]byte(reply))
+		}(reply)
+
+		c := zlib.NewConn(client)
+		c.SetDeadline(time.Now().Add(3 * time.Second))
+		if err := c.WhoisQuery("example.com", 8); err != nil {
+			t.Fatalf("WhoisQuery failed: %s", err)
+		}
+		w := c.GrabData().Whois
+		if truncated := len(reply) > 8; w.Response != "12345678" || w.Truncated != truncated {
+			t.Errorf("Wrong result for a %d byte reply: %q, truncated %v", len(reply), w.Response, w.Truncated)
+		}
+		client.Close()
+	}
+}
+
+func TestIRCProbeLongLine(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		go server.Write([]byte(":irc.example.com NOTICE * :" + strings.Repeat("x", 10000) + "\r\n"))
+		reader := bufio.NewReader(server)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || line == "QUIT\r\n" {
+				return
+			}
+		}
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.IRCProbe("zgrab"); err != nil {
+		t.Fatalf("IRCProbe failed: %s", err)
+	}
+	l := c.GrabData().IRC
+	if !l.LineTruncated || len(l.Raw) != 8192+512 {
+		t.Errorf("Long line not truncated: %d bytes, truncated %v", len(l.Raw), l.LineTruncated)
+	}
+}
+
+func TestGopherProbe(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		line, err := bufio.NewReader(server).ReadString('\n')
+		if err != nil || line != "\r\n" {
+			t.Errorf("Wrong selector - expected: %q, got: %q (%v)", "\r\n", line, err)
+			return
+		}
+		server.Write([]byte("iWelcome\tfake\t(NULL)\t0\r\n1Docs\t/docs\tgopher.example.com\t70\r\n.\r\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.GopherProbe(""); err != nil {
+		t.Fatalf("GopherProbe failed: %s", err)
+	}
+
+	g := c.GrabData().Gopher
+	want := []zlib.GopherMenuItem{
+		{Type: "i", Display: "Welcome", Selector: "fake", Host: "(NULL)", Port: 0},
+		{Type: "1", Display: "Docs", Selector: "/docs", Host: "gopher.example.com", Port: 70},
+	}
+	if !reflect.DeepEqual(g.Menu, want) {
+		t.Errorf("Wrong menu:\n got: %+v\nwant: %+v", g.Menu, want)
+	}
+}
+
+func TestMQTTProbe(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		connect := make([]byte, 20)
+		if _, err := io.ReadFull(server, connect); err != nil {
+			t.Errorf("Reading CONNECT failed: %s", err)
+			return
+		}
+		want := []byte{0x10, 0x12, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x05, 0x02, 0x00, 0x3c, 0x00, 0x00, 0x05, 'z', 'g', 'r', 'a', 'b'}
+		if !bytes.Equal(connect, want) {
+			t.Errorf("Wrong CONNECT - expected: %x, got: %x", want, connect)
+			return
+		}
+		server.Write([]byte{0x20, 0x06, 0x00, 0x00, 0x03, 0x21, 0x00, 0x0a})
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetMQTTProtocolLevel(zlib.MQTTProtocolLevel5)
+	if err := c.MQTTProbe(); err != nil {
+		t.Fatalf("MQTTProbe failed: %s", err)
+	}
+
+	want := &zlib.MQTTLog{
+		ProtocolLevel:    zlib.MQTTProtocolLevel5,
+		ReturnCodeName:   "success",
+		AnonymousAllowed: true,
+		Properties:       []byte{0x21, 0x00, 0x0a},
+	}
+	if got := c.GrabData().MQTT; !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong MQTT log:\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestSIPProbe(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		line, err := r.ReadString('\n')
+		if err != nil || line != "OPTIONS sip:sip.example.com SIP/2.0\r\n" {
+			t.Errorf("Wrong request line: %q (%v)", line, err)
+			return
+		}
+		for line != "\r\n" && err == nil {
+			line, err = r.ReadString('\n')
+		}
+		server.Write([]byte("SIP/2.0 100 Trying\r\nl: 0\r\n\r\n" +
+			"SIP/2.0 200 OK\r\n" +
+			"Server: Asterisk PBX 16.2.1\r\n" +
+			"Allow: INVITE, ACK, CANCEL, OPTIONS, BYE\r\n" +
+			"Content-Length: 4\r\n" +
+			"\r\n" +
+			"v=0\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetDomain("sip.example.com")
+	if err := c.SIPProbe(); err != nil {
+		t.Fatalf("SIPProbe failed: %s", err)
+	}
+
+	want := &zlib.SIPLog{
+		StatusLine: "SIP/2.0 200 OK",
+		StatusCode: 200,
+		Server:     "Asterisk PBX 16.2.1",
+		Allow:      []string{"INVITE", "ACK", "CANCEL", "OPTIONS", "BYE"},
+		Body:       "v=0\n",
+	}
+	if got := c.GrabData().SIP; !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong SIP log:\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestSIPProbeLongHeader(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		line, err := r.ReadString('\n')
+		for line != "\r\n" && err == nil {
+			line, err = r.ReadString('\n')
+		}
+		// A header that never ends must not be buffered until the deadline
+		if _, err := server.Write([]byte("SIP/2.0 200 OK\r\nServer: ")); err != nil {
+			return
+		}
+		filler := bytes.Repeat([]byte("a"), 4096)
+		for {
+			if _, err := server.Write(filler); err != nil {
+				return
+			}
+		}
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetDomain("sip.example.com")
+	err := c.SIPProbe()
+	if err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Fatalf("Expected an oversized header error, got %v", err)
+	}
+	if got := c.GrabData().SIP.StatusCode; got != 200 {
+		t.Errorf("Status line was not recorded: %d", got)
+	}
+}
+
+func TestConnReset(t *testing.T) {
+	first, _ := net.Pipe()
+	c := zlib.NewConnWithConfig(first, zlib.ConnConfig{Domain: "example.com", NoSNI: true})
+	c.GrabData().Banner = "stale"
+
+	second, _ := net.Pipe()
+	c.Reset(second)
+	if c.GrabData().Banner != "" {
+		t.Errorf("Reset kept grab data: %q", c.GrabData().Banner)
+	}
+	if c.Domain != "example.com" || !c.NoSNI {
+		t.Errorf("Reset dropped configuration: %+v", c.ConnConfig)
+	}
+
+	// The connect time of a dialed connection does not carry over
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	defer ln.Close()
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err = d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	third, _ := net.Pipe()
+	c.Reset(third)
+	c.ConnectOnly()
+	if micros := c.GrabData().Connect.ConnectTimeMicros; micros != 0 {
+		t.Errorf("Reset kept connect time: %dus", micros)
+	}
+}
+
+func TestTLSConfigFingerprintIsStable(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	fingerprint := func() []byte {
+		d := zlib.Dialer{Timeout: 3 * time.Second}
+		c, err := d.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %s", err)
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(3 * time.Second))
+		if err := c.TLSHandshake(); err != nil {
+			t.Fatalf("TLSHandshake failed: %s", err)
+		}
+		return c.GrabData().TLSHandshake.ConfigFingerprint
+	}
+	first, second := fingerprint(), fingerprint()
+	if len(first) != 32 {
+		t.Fatalf("Wrong fingerprint length: %d", len(first))
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("Fingerprint changed between connections: %x, %x", first, second)
+	}
+}
+
+func TestForceCipher(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	c.ForceCipher(0xc02f)
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake failed: %s", err)
+	}
+	hl := c.GrabData().TLSHandshake
+	if hl.ClientHello == nil || len(hl.ClientHello.CipherSuites) != 1 || hl.ClientHello.CipherSuites[0] != 0xc02f {
+		t.Errorf("ClientHello log does not record the forced suite: %+v", hl.ClientHello)
+	}
+	if suite := hl.ServerHello.CipherSuite; suite != 0xc02f {
+		t.Errorf("Wrong cipher suite - expected: 0xc02f, got: %#04x", uint16(suite))
+	}
+	if class := hl.ServerHello.CipherSuiteClass; class == nil || !class.IsAEAD || !class.ForwardSecret || class.IsCBC {
+		t.Errorf("Wrong cipher suite class: %+v", class)
+	}
+}
+
+func TestForceCipherRejected(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(server, header); err != nil {
+			t.Errorf("Reading ClientHello failed: %s", err)
+			return
+		}
+		io.CopyN(ioutil.Discard, server, int64(header[3])<<8|int64(header[4]))
+		// Fatal handshake_failure alert
+		server.Write([]byte{0x15, 0x03, 0x01, 0x00, 0x02, 0x02, 0x28})
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	// TLS_DHE_RSA_WITH_AES_128_CBC_SHA
+	c.ForceCipher(0x0033)
+	err := c.TLSHandshake()
+	if err == nil || !strings.Contains(err.Error(), "forced cipher suite") {
+		t.Errorf("Expected a forced cipher suite error, got: %v", err)
+	}
+}
+
+func TestSupportedCurves(t *testing.T) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.TLS = &tls.Config{CurvePreferences: []tls.CurveID{tls.X25519}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	handshake := func(curve ztls.CurveID) (*zlib.Conn, error) {
+		d := zlib.Dialer{Timeout: 3 * time.Second}
+		c, err := d.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %s", err)
+		}
+		c.SetDeadline(time.Now().Add(3 * time.Second))
+		// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+		c.ForceCipher(0xc02f)
+		c.SetSupportedCurves([]ztls.CurveID{curve})
+		return c, c.TLSHandshake()
+	}
+
+	c, err := handshake(ztls.CurveX25519)
+	if err != nil {
+		t.Fatalf("TLSHandshake failed: %s", err)
+	}
+	defer c.Close()
+	hl := c.GrabData().TLSHandshake
+	if !c.GrabData().IsTLS {
+		t.Errorf("X25519 handshake did not complete")
+	}
+	if curves := hl.ClientHello.SupportedCurves; len(curves) != 1 || curves[0] != ztls.CurveX25519 {
+		t.Errorf("Wrong offered curves: %v", curves)
+	}
+	if skx := hl.ServerKeyExchange; skx == nil || skx.ECDHParams == nil || skx.ECDHParams.TLSCurveID != 29 {
+		t.Errorf("Server curve not recorded as X25519: %+v", skx)
+	}
+
+	c, err = handshake(ztls.CurveP256)
+	defer c.Close()
+	if err == nil {
+		t.Errorf("Handshake offering only P-256 succeeded against an X25519-only server")
+	}
+}
+
+func TestClientHelloMalformation(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	// With SCT and extended master secret offered, as zgrab does by
+	// default, the final extension is empty
+	for _, defaults := range []bool{false, true} {
+		for _, kind := range ztls.ClientHelloMalformations {
+			d := zlib.Dialer{Timeout: 3 * time.Second}
+			c, err := d.Dial("tcp", ts.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("Dial failed: %s", err)
+			}
+			c.SetDeadline(time.Now().Add(3 * time.Second))
+			c.SetClientHelloMalformation(kind)
+			if defaults {
+				c.SetSignedCertificateTimestampExt()
+				c.SetOfferExtendedMasterSecret()
+			}
+			if err := c.TLSHandshake(); err == nil {
+				t.Errorf("%s (defaults %v): handshake succeeded", kind, defaults)
+			}
+			m := c.GrabData().TLSHandshake.Malformation
+			if m == nil || m.Kind != kind {
+				t.Errorf("%s (defaults %v): malformation not recorded: %+v", kind, defaults, m)
+			} else if m.Outcome != ztls.MalformationOutcomeAlert || m.Alert == "" {
+				t.Errorf("%s (defaults %v): expected an alert, got: %+v", kind, defaults, m)
+			}
+			c.Close()
+		}
+	}
+}
+
+// warnFirstConn sends a warning-level unrecognized_name alert before the
+// first record written through it
+type warnFirstConn struct {
+	net.Conn
+	warned bool
+}
+
+func (w *warnFirstConn) Write(b []byte) (int, error) {
+	if !w.warned {
+		w.warned = true
+		if _, err := w.Conn.Write([]byte{0x15, 0x03, 0x01, 0x00, 0x02, 0x01, 0x70}); err != nil {
+			return 0, err
+		}
+	}
+	return w.Conn.Write(b)
+}
+
+func TestUnrecognizedNameWarning(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		tlsServer := tls.Server(&warnFirstConn{Conn: server}, ts.TLS.Clone())
+		defer tlsServer.Close()
+		tlsServer.Handshake()
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetDomain("unknown.example.com")
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake failed after a warning alert: %s", err)
+	}
+	hs := c.GrabData().TLSHandshake
+	if !hs.UnrecognizedName || !reflect.DeepEqual(hs.WarningAlerts, []string{"unrecognized name"}) {
+		t.Errorf("Warning alert not recorded: %t %v", hs.UnrecognizedName, hs.WarningAlerts)
+	}
+}
+
+func TestClientHelloPadding(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	// A long server name brings the ClientHello into the padded range
+	c.SetDomain(strings.Repeat("a.", 80) + "example.com")
+	if err := c.CheckClientHelloPadding(); err != nil {
+		t.Fatalf("CheckClientHelloPadding failed: %s", err)
+	}
+	cp := c.GrabData().ClientHelloPadding
+	if !cp.Unpadded.Success || cp.Unpadded.PaddingLength != 0 {
+		t.Errorf("Wrong unpadded probe: %+v", cp.Unpadded)
+	}
+	if !cp.Padded.Success || cp.Padded.PaddingLength == 0 {
+		t.Errorf("Wrong padded probe: %+v", cp.Padded)
+	}
+	if cp.Changed {
+		t.Error("Padding reported as changing the outcome")
+	}
+}
+
+// A proxiedConn records what recordingProxy saw from one client connection
+type proxiedConn struct {
+	bytes   int
+	maxRead int
+	reset   bool
+}
+
+// recordingProxy forwards connections to backend and reports each one on
+// the returned channel once the client closes it
+func recordingProxy(t *testing.T, backend string) (net.Listener, <-chan proxiedConn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	conns := make(chan proxiedConn, 16)
+	go func() {
+		for {
+			client, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer client.Close()
+				server, err := net.Dial("tcp", backend)
+				if err != nil {
+					return
+				}
+				defer server.Close()
+				go io.Copy(client, server)
+				var pc proxiedConn
+				buf := make([]byte, 4096)
+				for {
+					n, err := client.Read(buf)
+					pc.bytes += n
+					if n > pc.maxRead {
+						pc.maxRead = n
+					}
+					server.Write(buf[:n])
+					if err != nil {
+						pc.reset = strings.Contains(err.Error(), "connection reset")
+						break
+					}
+				}
+				conns <- pc
+			}()
+		}
+	}()
+	return ln, conns
+}
+
+func TestProbeConnectionsFragmentWrites(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+	ln, conns := recordingProxy(t, ts.Listener.Addr().String())
+	defer ln.Close()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	c.SetWriteFragmentSize(16)
+	c.SetWriteFragmentDelay(5 * time.Millisecond)
+	if err := c.CheckClientHelloPadding(); err != nil {
+		t.Fatalf("CheckClientHelloPadding failed: %s", err)
+	}
+	c.Close()
+
+	// The connection itself and one for each padding probe
+	total := 0
+	for i := 0; i < 3; i++ {
+		pc := <-conns
+		if pc.maxRead > 16 {
+			t.Errorf("Write of %d bytes not fragmented", pc.maxRead)
+		}
+		total += pc.bytes
+	}
+	if written := c.GrabData().BytesWritten; written != int64(total) {
+		t.Errorf("Wrong byte count - expected: %d, got: %d", total, written)
+	}
+}
+
+func TestProbeConnectionsSocketOptions(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+	ln, conns := recordingProxy(t, ts.Listener.Addr().String())
+	defer ln.Close()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	// A zero linger resets the connection on close instead of leaving it
+	// in TIME_WAIT
+	if err := c.SetTCPLinger(0); err != nil {
+		t.Fatalf("SetTCPLinger failed: %s", err)
+	}
+	if err := c.FullTLSProfile(3); err != nil {
+		t.Fatalf("FullTLSProfile failed: %s", err)
+	}
+	c.Close()
+
+	for i := 0; i < 4; i++ {
+		if pc := <-conns; !pc.reset {
+			t.Errorf("Connection %d closed without a reset", i)
+		}
+	}
+}
+
+func TestFullTLSProfile(t *testing.T) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.TLS = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	profile := func(maxHandshakes int) *zlib.TLSProfile {
+		d := zlib.Dialer{Timeout: 3 * time.Second}
+		c, err := d.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %s", err)
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := c.FullTLSProfile(maxHandshakes); err != nil {
+			t.Fatalf("FullTLSProfile failed: %s", err)
+		}
+		return c.GrabData().TLSProfile
+	}
+
+	p := profile(100)
+	if p.Truncated || len(p.Versions) != 4 {
+		t.Fatalf("Wrong profile: %+v", p)
+	}
+	tls12 := p.Versions[0]
+	want := []ztls.CipherSuite{ztls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, ztls.TLS_RSA_WITH_AES_128_CBC_SHA}
+	if !tls12.Supported || !reflect.DeepEqual(tls12.CipherSuites, want) || !tls12.ServerCipherPreference {
+		t.Errorf("Wrong TLS 1.2 profile: %+v", tls12)
+	}
+	for _, vp := range p.Versions[1:] {
+		if vp.Supported {
+			t.Errorf("Unexpected support for %s", vp.Version)
+		}
+	}
+	if !p.ForwardSecrecy || p.ECDHParams == nil {
+		t.Errorf("ECDHE key exchange not recorded: %+v", p)
+	}
+
+	if p := profile(2); !p.Truncated || p.Handshakes != 2 {
+		t.Errorf("Handshake limit not applied: %+v", p)
+	}
+}
+
+func TestTLSHandshakeNested(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		outer := tls.Server(server, ts.TLS.Clone())
+		defer outer.Close()
+		if err := outer.Handshake(); err != nil {
+			t.Errorf("Outer handshake failed: %s", err)
+			return
+		}
+		inner := tls.Server(outer, ts.TLS.Clone())
+		if err := inner.Handshake(); err != nil {
+			t.Errorf("Inner handshake failed: %s", err)
+			return
+		}
+		inner.Write([]byte("hello from the origin\n"))
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.TLSHandshakeNested(); err == nil {
+		t.Error("Nested handshake succeeded without an outer one")
+	}
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake failed: %s", err)
+	}
+	if err := c.TLSHandshakeNested(); err != nil {
+		t.Fatalf("TLSHandshakeNested failed: %s", err)
+	}
+	data := c.GrabData()
+	if data.TLSHandshake == nil || data.NestedTLSHandshake == nil || data.NestedTLSHandshake.ServerCertificates == nil {
+		t.Fatalf("Handshakes not recorded: outer %v, nested %v", data.TLSHandshake, data.NestedTLSHandshake)
+	}
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil || line != "hello from the origin\n" {
+		t.Errorf("Wrong data through the tunnel: %q (%v)", line, err)
+	}
+}
+
+func TestSufficientSCTs(t *testing.T) {
+	sct := func(log byte, source string) ztls.ParsedAndRawSCT {
+		return ztls.ParsedAndRawSCT{Parsed: &ct.SignedCertificateTimestamp{LogID: ct.SHA256Hash{log}}, Source: source}
+	}
+	now := time.Now()
+	short := &zx509.Certificate{NotBefore: now, NotAfter: now.Add(90 * 24 * time.Hour)}
+	long := &zx509.Certificate{NotBefore: now, NotAfter: now.Add(365 * 24 * time.Hour)}
+	embedded := ztls.SCTSourceCertificate
+	tests := []struct {
+		scts []ztls.ParsedAndRawSCT
+		leaf *zx509.Certificate
+		want bool
+	}{
+		{[]ztls.ParsedAndRawSCT{sct(1, embedded), sct(2, embedded)}, short, true},
+		{[]ztls.ParsedAndRawSCT{sct(1, embedded), sct(2, embedded)}, long, false},
+		{[]ztls.ParsedAndRawSCT{sct(1, embedded), sct(2, embedded), sct(3, embedded)}, long, true},
+		// Two SCTs from the same log count once
+		{[]ztls.ParsedAndRawSCT{sct(1, embedded), sct(1, embedded)}, short, false},
+		{[]ztls.ParsedAndRawSCT{sct(1, ztls.SCTSourceTLSExtension), sct(2, ztls.SCTSourceOCSP)}, long, true},
+		{[]ztls.ParsedAndRawSCT{sct(1, ztls.SCTSourceTLSExtension), sct(2, embedded)}, long, false},
+	}
+	for i, test := range tests {
+		if got := ztls.SufficientSCTs(test.scts, test.leaf); got != test.want {
+			t.Errorf("Case %d: expected %t, got %t", i, test.want, got)
+		}
+	}
+}
+
+// testSCT returns a serialized v1 SCT from the log with the given ID byte.
+func testSCT(log byte) []byte {
+	sct := []byte{0}                             // version
+	sct = append(sct, log)                       // log ID
+	sct = append(sct, make([]byte, 31)...)       // rest of the log ID
+	sct = append(sct, 0, 0, 1, 0x5f, 0, 0, 0, 0) // timestamp
+	sct = append(sct, 0, 0)                      // extensions
+	sct = append(sct, 4, 3, 0, 2, 0xaa, 0xbb)    // SHA-256 with ECDSA
+	return sct
+}
+
+// testSCTList wraps scts in a TLS-encoded SignedCertificateTimestampList
+// inside an OCTET STRING, as carried by the certificate and OCSP extensions.
+func testSCTList(t *testing.T, scts ...[]byte) []byte {
+	var list []byte
+	for _, sct := range scts {
+		list = append(list, byte(len(sct)>>8), byte(len(sct)))
+		list = append(list, sct...)
+	}
+	list = append([]byte{byte(len(list) >> 8), byte(len(list))}, list...)
+	value, err := asn1.Marshal(list)
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	return value
+}
+
+// testOCSPResponse returns a DER-encoded OCSP response whose single
+// response carries sctList in the SCT list extension. The signature is not
+// valid; only the structure is needed.
+func testOCSPResponse(t *testing.T, sctList []byte) []byte {
+	type singleResponse struct {
+		CertID           asn1.RawValue
+		CertStatus       asn1.RawValue
+		ThisUpdate       time.Time        `asn1:"generalized"`
+		SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+	}
+	type responseData struct {
+		RawResponderID asn1.RawValue
+		ProducedAt     time.Time `asn1:"generalized"`
+		Responses      []singleResponse
+	}
+	type basicResponse struct {
+		TBSResponseData    responseData
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Signature          asn1.BitString
+	}
+	type responseBytes struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	}
+	type response struct {
+		Status   asn1.Enumerated
+		Response responseBytes `asn1:"explicit,tag:0,optional"`
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	certID, _ := asn1.Marshal(struct{ Serial *big.Int }{big.NewInt(1)})
+	single := singleResponse{
+		CertID:     asn1.RawValue{FullBytes: certID},
+		CertStatus: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0},
+		ThisUpdate: now,
+	}
+	if sctList != nil {
+		single.SingleExtensions = []pkix.Extension{{
+			Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5},
+			Value: sctList,
+		}}
+	}
+	basic, err := asn1.Marshal(basicResponse{
+		TBSResponseData: responseData{
+			RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: []byte{4, 0}},
+			ProducedAt:     now,
+			Responses:      []singleResponse{single},
+		},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}},
+		Signature:          asn1.BitString{Bytes: []byte{0}, BitLength: 8},
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	der, err := asn1.Marshal(response{Response: responseBytes{
+		ResponseType: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1},
+		Response:     basic,
+	}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	return der
+}
+
+// grabSCTs handshakes with a server whose leaf embeds certSCTs (when not
+// nil) and which staples ocsp, returning the SCTs zgrab collected.
+func grabSCTs(t *testing.T, certSCTs, ocsp []byte) ([]ztls.ParsedAndRawSCT, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if certSCTs != nil {
+		template.ExtraExtensions = []pkix.Extension{{
+			Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2},
+			Value: certSCTs,
+		}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %s", err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		tlsServer := tls.Server(server, &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, OCSPStaple: ocsp}},
+			MaxVersion:   tls.VersionTLS12,
+		})
+		defer tlsServer.Close()
+		tlsServer.Handshake()
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	err = c.TLSHandshake()
+	return c.GrabData().TLSHandshake.SCTList, err
+}
+
+func TestCollectSCTs(t *testing.T) {
+	valid := testSCTList(t, testSCT(1), testSCT(2))
+	// The SCT list is cut short inside an intact OCTET STRING
+	truncated := append([]byte(nil), valid[:len(valid)-3]...)
+	truncated[1] -= 3
+	tests := []struct {
+		name     string
+		certSCTs []byte
+		ocsp     []byte
+		want     map[string]int
+		wantErr  bool
+	}{
+		{"certificate", valid, nil, map[string]int{ztls.SCTSourceCertificate: 2}, false},
+		{"ocsp", nil, testOCSPResponse(t, valid), map[string]int{ztls.SCTSourceOCSP: 2}, false},
+		{"both", testSCTList(t, testSCT(3)), testOCSPResponse(t, valid), map[string]int{ztls.SCTSourceCertificate: 1, ztls.SCTSourceOCSP: 2}, false},
+		{"no ocsp extension", valid, testOCSPResponse(t, nil), map[string]int{ztls.SCTSourceCertificate: 2}, false},
+		{"truncated ocsp list", valid, testOCSPResponse(t, truncated), map[string]int{ztls.SCTSourceCertificate: 2}, false},
+		{"ocsp list not an octet string", valid, testOCSPResponse(t, []byte{0x05, 0x00}), map[string]int{ztls.SCTSourceCertificate: 2}, false},
+		{"truncated ocsp response", valid, testOCSPResponse(t, valid)[:20], map[string]int{ztls.SCTSourceCertificate: 2}, false},
+		{"malformed ocsp response", valid, []byte{0x30, 0x03, 0x0a, 0x01}, map[string]int{ztls.SCTSourceCertificate: 2}, false},
+		// The certificate parser already rejects a malformed embedded list,
+		// so the leaf never reaches SCT collection
+		{"truncated certificate list", truncated, testOCSPResponse(t, valid), map[string]int{}, true},
+		{"certificate list not an octet string", []byte{0x05, 0x00}, nil, map[string]int{}, true},
+	}
+	for _, test := range tests {
+		scts, err := grabSCTs(t, test.certSCTs, test.ocsp)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: unexpected handshake result: %v", test.name, err)
+		}
+		got := make(map[string]int)
+		for _, sct := range scts {
+			if sct.Parsed == nil {
+				t.Errorf("%s: SCT from %s not parsed", test.name, sct.Source)
+			}
+			got[sct.Source]++
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestFlattenServerHandshake(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake failed: %s", err)
+	}
+	data := c.GrabData()
+	data.FlattenTLS()
+	if data.TLSHandshake != nil {
+		t.Errorf("TLSHandshake kept after flattening")
+	}
+	f := data.TLSFlat
+	if f == nil || !f.HasServerHello || !f.HasCertificates || !f.ServerFinished {
+		t.Fatalf("Flat handshake missing messages: %+v", f)
+	}
+	if f.KeyExchange != ztls.FlatKeyExchangeECDHE || f.ECDHCurveID == 0 {
+		t.Errorf("Wrong key exchange: %q, curve %d", f.KeyExchange, f.ECDHCurveID)
+	}
+	if len(f.LeafSHA256) != 64 || f.LeafSubjectDN == "" {
+		t.Errorf("Leaf not recorded: %q, %q", f.LeafSHA256, f.LeafSubjectDN)
+	}
+
+	// A missing handshake has the same fields, with lists empty, not null
+	keys := func(v interface{}) map[string]interface{} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal failed: %s", err)
+		}
+		m := make(map[string]interface{})
+		json.Unmarshal(b, &m)
+		return m
+	}
+	full, empty := keys(f), keys((*ztls.ServerHandshake)(nil).Flatten())
+	if len(full) != len(empty) {
+		t.Errorf("Field count differs: %d with a handshake, %d without", len(full), len(empty))
+	}
+	for k, v := range empty {
+		if _, ok := full[k]; !ok {
+			t.Errorf("Field %s only present without a handshake", k)
+		}
+		if v == nil {
+			t.Errorf("Field %s is null", k)
+		}
+	}
+}
+
+func TestParseCertLimit(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+	// Send the leaf twice so there is a chain to leave unparsed
+	cert := &ts.TLS.Certificates[0]
+	cert.Certificate = append(cert.Certificate, cert.Certificate[0])
+
+	for _, n := range []int{0, 1} {
+		d := zlib.Dialer{Timeout: 3 * time.Second}
+		c, err := d.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %s", err)
+		}
+		c.SetDeadline(time.Now().Add(3 * time.Second))
+		c.SetParseCertLimit(n)
+		if err := c.TLSHandshake(); err != nil {
+			t.Fatalf("limit %d: TLSHandshake failed: %s", n, err)
+		}
+		certs := c.GrabData().TLSHandshake.ServerCertificates
+		if len(certs.Certificate.Raw) == 0 || len(certs.Chain) != 1 || len(certs.Chain[0].Raw) == 0 {
+			t.Errorf("limit %d: raw certificates not recorded: %+v", n, certs)
+		}
+		if leafParsed := certs.Certificate.Parsed != nil; leafParsed != (n == 1) {
+			t.Errorf("limit %d: leaf parsed: %v", n, leafParsed)
+		}
+		if certs.Chain[0].Parsed != nil || certs.Validation != nil {
+			t.Errorf("limit %d: chain was parsed or validated", n)
+		}
+		c.Close()
+	}
+}
+
+func TestServerKeyExchangeSignatureAlgorithm(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake failed: %s", err)
+	}
+	alg := c.GrabData().TLSHandshake.ServerKeyExchange.SignatureAlgorithm
+	if alg == nil {
+		t.Fatal("No signature algorithm recorded")
+	}
+	if alg.Signature.String() != "rsa" || alg.Hash.String() == "sha1" {
+		t.Errorf("Unexpected signature algorithm: %s with %s", alg.Signature, alg.Hash)
+	}
+}
+
+func TestServerKeyExchangeECDSASignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %s", err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		tlsServer := tls.Server(server, &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+			MaxVersion:   tls.VersionTLS12,
+		})
+		defer tlsServer.Close()
+		tlsServer.Handshake()
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake failed: %s", err)
+	}
+	skx := c.GrabData().TLSHandshake.ServerKeyExchange
+	if skx.ECDHParams == nil || skx.Signature == nil {
+		t.Fatalf("ECDHE parameters or signature not recorded: %+v", skx)
+	}
+	if skx.Signature.Type != "ecdsa" || !skx.Signature.Valid || skx.SignatureError != "" {
+		t.Errorf("ECDSA signature not verified: %+v (%s)", skx.Signature, skx.SignatureError)
+	}
+}
+
+func TestParseServerKeyExchangeParams(t *testing.T) {
+	// p = 23, g = 5, Ys = 8, followed by a signature
+	dhe := []byte{0x00, 0x01, 23, 0x00, 0x01, 5, 0x00, 0x01, 8, 0x04, 0x01, 0x00, 0x00}
+	dh, err := ztls.ParseDHParams(dhe)
+	if err != nil {
+		t.Fatalf("ParseDHParams failed: %s", err)
+	}
+	if dh.Prime.Int64() != 23 || dh.Generator.Int64() != 5 || dh.ServerPublic.Int64() != 8 {
+		t.Errorf("Wrong DH parameters: %+v", dh)
+	}
+	if _, err := ztls.ParseDHParams(dhe[:7]); err == nil {
+		t.Error("Truncated DH parameters were accepted")
+	}
+
+	// A 2-byte modulus and exponent 65537
+	export := []byte{0x00, 0x02, 0xc3, 0x51, 0x00, 0x03, 0x01, 0x00, 0x01}
+	rsa, err := ztls.ParseRSAExportParams(export)
+	if err != nil {
+		t.Fatalf("ParseRSAExportParams failed: %s", err)
+	}
+	if rsa.N.Int64() != 0xc351 || rsa.E != 65537 {
+		t.Errorf("Wrong RSA parameters: N %s, E %d", rsa.N, rsa.E)
+	}
+}
+
+func TestDHSecurityLevel(t *testing.T) {
+	oakley2, _ := new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B"+
+		"302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B"+
+		"0BFF5CB6F406B7EDEE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381"+
+		"FFFFFFFFFFFFFFFF", 16)
+	unique1024 := new(big.Int).Add(oakley2, big.NewInt(-2))
+	modSSL, _ := new(big.Int).SetString("E6969D3D495BE32C7CF180C3BDD4798E91B7818251BB055E"+
+		"2A2064904A79A770FA15A259CBD523A6A6EF09C43048D5A22F971F3C20129B48"+
+		"000E6EDD061CBC053E371D794E5327DF611EBBBE1BAC9B5C6044CF023D76E05E"+
+		"EA9BAD991B13A63C974E9EF1839EB5DB125136F7262E56A8871538DFD823C650"+
+		"5085E21F0DD5C86B", 16)
+	rfc5114, _ := new(big.Int).SetString("B10B8F96A080E01DDE92DE5EAE5D54EC52C99FBCFB06A3C6"+
+		"9A6A9DCA52D23B616073E28675A23D189838EF1E2EE652C013ECB4AEA9061123"+
+		"24975C3CD49B83BFACCBDD7D90C4BD7098488E9C219A73724EFFD6FAE5644738"+
+		"FAA31A4FF55BCCC0A151AF5F0DC8B4BD45BF37DF365C1A65E68CFDA76D4DA708"+
+		"DF1FB2BC2E4A4371", 16)
+	tests := []struct {
+		prime *big.Int
+		weak  bool
+		level int
+	}{
+		{nil, false, 0},
+		{big.NewInt(23), false, 0},
+		{new(big.Int).Lsh(big.NewInt(1), 511), false, 56},
+		{unique1024, false, 80},
+		{oakley2, true, 64},
+		{modSSL, true, 64},
+		{rfc5114, true, 64},
+		{new(big.Int).Lsh(big.NewInt(1), 2047), false, 112},
+		{new(big.Int).Lsh(big.NewInt(1), 3071), false, 128},
+	}
+	for _, test := range tests {
+		p := &jsonKeys.DHParams{Prime: test.prime}
+		if weak := p.IsWeakGroup(); weak != test.weak {
+			t.Errorf("Wrong IsWeakGroup for %d-bit prime - expected: %t, got: %t", test.prime.BitLen(), test.weak, weak)
+		}
+		if level := p.SecurityLevel(); level != test.level {
+			t.Errorf("Wrong SecurityLevel for %d-bit prime - expected: %d, got: %d", test.prime.BitLen(), test.level, level)
+		}
+	}
+}
+
+func TestCompressedCertificate(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+	der := ts.Certificate().Raw
+
+	// A Certificate message body holding der, compressed with zlib
+	n := len(der)
+	body := append([]byte{byte((n + 3) >> 16), byte((n + 3) >> 8), byte(n + 3), byte(n >> 16), byte(n >> 8), byte(n)}, der...)
+	var compressed bytes.Buffer
+	w := stdzlib.NewWriter(&compressed)
+	w.Write(body)
+	w.Close()
+	m := len(body)
+	cl := compressed.Len()
+	certMsg := []byte{25, byte((8 + cl) >> 16), byte((8 + cl) >> 8), byte(8 + cl), 0x00, 0x01, byte(m >> 16), byte(m >> 8), byte(m), byte(cl >> 16), byte(cl >> 8), byte(cl)}
+	certMsg = append(certMsg, compressed.Bytes()...)
+
+	serverHello := append([]byte{2, 0, 0, 38, 0x03, 0x03}, make([]byte, 32)...)
+	serverHello = append(serverHello, 0x00, 0x00, 0x2f, 0x00)
+	handshake := append(serverHello, certMsg...)
+	record := append([]byte{0x16, 0x03, 0x03, byte(len(handshake) >> 8), byte(len(handshake))}, handshake...)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(server, header); err != nil {
+			t.Errorf("Reading ClientHello failed: %s", err)
+			return
+		}
+		hello := make([]byte, int(header[3])<<8|int(header[4]))
+		io.ReadFull(server, hello)
+		// compress_certificate offering zlib
+		if !bytes.Contains(hello, []byte{0x00, 0x1b, 0x00, 0x03, 0x02, 0x00, 0x01}) {
+			t.Errorf("ClientHello does not offer zlib certificate compression")
+		}
+		server.Write(record)
+		io.Copy(ioutil.Discard, server)
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetTLSCertsOnly()
+	c.SetOfferCertCompression()
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake failed: %s", err)
+	}
+	certs := c.GrabData().TLSHandshake.ServerCertificates
+	if certs.Compression != "zlib" {
+		t.Errorf("Wrong compression - expected: zlib, got: %q", certs.Compression)
+	}
+	if !bytes.Equal(certs.Certificate.Raw, der) {
+		t.Errorf("Decompressed certificate does not match")
+	}
+}
+
+func TestEncryptThenMACWithCBC(t *testing.T) {
+	// A ServerHello choosing TLS_RSA_WITH_AES_128_CBC_SHA with encrypt_then_mac
+	serverHello := append([]byte{2, 0, 0, 44, 0x03, 0x03}, make([]byte, 32)...)
+	serverHello = append(serverHello, 0x00, 0x00, 0x2f, 0x00, 0x00, 0x04, 0x00, 0x16, 0x00, 0x00)
+	record := append([]byte{0x16, 0x03, 0x03, 0x00, byte(len(serverHello))}, serverHello...)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(server, header); err != nil {
+			t.Errorf("Reading ClientHello failed: %s", err)
+			return
+		}
+		io.ReadFull(server, make([]byte, int(header[3])<<8|int(header[4])))
+		server.Write(record)
+		io.Copy(ioutil.Discard, server)
+	}()
+
+	c := zlib.NewConn(client)
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	c.SetOfferEncryptThenMAC()
+	if err := c.TLSHandshake(); err != ztls.ErrEncryptThenMACUnsupported {
+		t.Fatalf("Wrong error - expected: %v, got: %v", ztls.ErrEncryptThenMACUnsupported, err)
+	}
+	if sh := c.GrabData().TLSHandshake.ServerHello; sh == nil || !sh.EncryptThenMAC {
+		t.Errorf("ServerHello with encrypt_then_mac not logged: %+v", sh)
+	}
+}
+
+func TestCheckTicketKeyReuse(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	d := zlib.Dialer{Timeout: 3 * time.Second}
+	c, err := d.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.CheckTicketKeyReuse(3); err != nil {
+		t.Fatalf("CheckTicketKeyReuse failed: %s", err)
+	}
+	e := c.GrabData().TicketKeyReuse
+	if e.Error != "" || len(e.Tickets) != 3 {
+		t.Fatalf("Tickets not collected: %+v", e)
+	}
+	// The test server keeps one ticket key for its lifetime
+	if !e.Resumed || e.Estimate != zlib.TicketKeyShared {
+		t.Errorf("Shared ticket key not detected: resumed %v, estimate %q", e.Resumed, e.Estimate)
+	}
+}
@@ -15,6 +15,7 @@
 package zlib
 
 import (
+	"context"
 	"net"
 	"time"
 )
@@ -27,6 +28,9 @@ type Dialer struct {
 	KeepAlive time.Duration
 }
 
+// Dial connects to address. If the host is a name rather than an IP, it is
+// resolved first and the addresses tried in turn, with the lookup recorded
+// in the connection's GrabData.
 func (d *Dialer) Dial(network, address string) (*Conn, error) {
 	c := &Conn{}
 	netDialer := net.Dialer{
@@ -35,7 +39,71 @@ func (d *Dialer) Dial(network, address string) (*Conn, error) {
 		LocalAddr: d.LocalAddr,
 		KeepAlive: d.KeepAlive,
 	}
-	var err error
-	c.conn, err = netDialer.Dial(network, address)
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		start := time.Now()
+		c.conn, err = netDialer.Dial(network, address)
+		c.connectTime = time.Since(start)
+		c.countBytes()
+		return c, err
+	}
+
+	ctx := context.Background()
+	if deadline := d.deadline(); !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	dns := &DNSLog{Name: host}
+	c.grabData.DNS = dns
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	dns.LookupTimeMicros = int64(time.Since(start) / time.Microsecond)
+	if err != nil {
+		return c, err
+	}
+	for _, addr := range addrs {
+		dns.Addresses = append(dns.Addresses, addr.IP.String())
+	}
+	for _, addr := range addrs {
+		start = time.Now()
+		c.conn, err = netDialer.Dial(network, net.JoinHostPort(addr.IP.String(), port))
+		c.connectTime = time.Since(start)
+		if err == nil {
+			dns.ResolvedIP = addr.IP.String()
+			break
+		}
+	}
+	c.countBytes()
 	return c, err
 }
+
+// deadline returns the earlier of Deadline and now plus Timeout, or the zero
+// time if neither is set.
+func (d *Dialer) deadline() time.Time {
+	deadline := d.Deadline
+	if d.Timeout != 0 {
+		if t := time.Now().Add(d.Timeout); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline
+}
+
+// A DNSLog records how Dialer resolved the name it was given. ResolvedIP
+// is the address the connection was made to.
+type DNSLog struct {
+	Name             string   `json:"name"`
+	Addresses        []string `json:"addresses,omitempty"`
+	ResolvedIP       string   `json:"resolved_ip,omitempty"`
+	LookupTimeMicros int64    `json:"lookup_time_us"`
+}
+
+// ConnectLog records a successful connection made without any further
+// protocol interaction, see Conn.ConnectOnly.
+type ConnectLog struct {
+	ConnectOnly       bool   `json:"connect_only"`
+	RemoteAddr        string `json:"remote_addr,omitempty"`
+	LocalAddr         string `json:"local_addr,omitempty"`
+	ConnectTimeMicros int64  `json:"connect_time_us"`
+}
@@ -0,0 +1,71 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// gopherMaxResponseSize bounds how much of a gopher response is read
+const gopherMaxResponseSize = 65536
+
+// A GopherLog holds the response to a gopher selector (RFC 1436)
+type GopherLog struct {
+	Selector  string           `json:"selector"`
+	Response  string           `json:"response,omitempty"`
+	Truncated bool             `json:"truncated,omitempty"`
+	Menu      []GopherMenuItem `json:"menu,omitempty"`
+}
+
+// A GopherMenuItem is one line of a gopher directory listing
+type GopherMenuItem struct {
+	Type     string `json:"type"`
+	Display  string `json:"display"`
+	Selector string `json:"selector"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+}
+
+// parseGopherMenu parses response as a directory listing, returning nil if
+// any line is not a well-formed menu item.
+func parseGopherMenu(response string) []GopherMenuItem {
+	var items []GopherMenuItem
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "." {
+			break
+		}
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line[1:], "\t")
+		if len(fields) < 4 {
+			return nil
+		}
+		port, err := strconv.Atoi(strings.TrimSpace(fields[3]))
+		if err != nil {
+			return nil
+		}
+		items = append(items, GopherMenuItem{
+			Type:     line[0:1],
+			Display:  fields[0],
+			Selector: fields[1],
+			Host:     fields[2],
+			Port:     port,
+		})
+	}
+	return items
+}
@@ -120,26 +120,49 @@ func makeDialer(c *Config) func(string) (*Conn, error) {
 			Deadline: deadline,
 		}
 		conn, err := d.Dial(proto, addr)
-		conn.maxTlsVersion = c.TLSVersion
+		conn.MaxTLSVersion = c.TLSVersion
 		if err == nil {
 			conn.SetDeadline(deadline)
+			err = setSocketOptions(c, conn)
 		}
 		return conn, err
 	}
 }
 
-func makeNetDialer(c *Config) func(string, string) (net.Conn, error) {
+// setSocketOptions applies the configured TCP keepalive, linger and write
+// fragmentation to conn
+func setSocketOptions(c *Config, conn *Conn) error {
+	conn.SetWriteFragmentDelay(c.WriteFragmentDelay)
+	conn.SetWriteFragmentSize(c.WriteFragmentSize)
+	if c.TCPKeepAlive != 0 {
+		if err := conn.SetTCPKeepAlive(c.TCPKeepAlive > 0, c.TCPKeepAlive); err != nil {
+			return err
+		}
+	}
+	if c.TCPLinger != nil {
+		return conn.SetTCPLinger(*c.TCPLinger)
+	}
+	return nil
+}
+
+// makeNetDialer returns a dial function for the HTTP transport. If limit is
+// non-zero, no connection deadline will be set past it.
+func makeNetDialer(c *Config, limit time.Time) func(string, string) (net.Conn, error) {
 	proto := "tcp"
 	timeout := c.Timeout
 	return func(net, addr string) (net.Conn, error) {
 		deadline := time.Now().Add(timeout)
+		if !limit.IsZero() && limit.Before(deadline) {
+			deadline = limit
+		}
 		d := Dialer{
 			Deadline: deadline,
 		}
 		conn, err := d.Dial(proto, addr)
-		conn.maxTlsVersion = c.TLSVersion
+		conn.MaxTLSVersion = c.TLSVersion
 		if err == nil {
 			conn.SetDeadline(deadline)
+			err = setSocketOptions(c, conn)
 		}
 		return conn.getUnderlyingConn(), err
 	}
@@ -153,6 +176,12 @@ func makeTLSConfig(config *Config, urlHost string) *tls.Config {
 	tlsConfig.RootCAs = config.RootCAPool
 	tlsConfig.HeartbeatEnabled = true
 	tlsConfig.ClientDSAEnabled = true
+	tlsConfig.MaxCertChainLength = config.TLSMaxCertChainLength
+	tlsConfig.LimitCertParsing = config.TLSLimitCertParsing
+	tlsConfig.ParseCertLimit = config.TLSParseCertLimit
+	tlsConfig.FallbackSCSV = config.TLSFallbackSCSV
+	tlsConfig.MaxFragmentLength = config.TLSMaxFragmentLength
+	tlsConfig.ClientHelloRecordVersion = config.TLSClientHelloRecordVersion
 	if config.DHEOnly {
 		tlsConfig.CipherSuites = tls.DHECiphers
 	}
@@ -186,6 +215,14 @@ func makeTLSConfig(config *Config, urlHost string) *tls.Config {
 		tlsConfig.CipherSuites = tls.SafariNoDHECiphers
 		tlsConfig.ForceSuites = true
 	}
+	if config.TLSForceCipher != 0 {
+		tlsConfig.CipherSuites = []uint16{config.TLSForceCipher}
+		tlsConfig.ForceSuites = true
+	}
+	if len(config.TLSCurves) > 0 {
+		tlsConfig.CurvePreferences = config.TLSCurves
+		tlsConfig.ExplicitCurvePreferences = true
+	}
 	if config.TLSExtendedRandom {
 		tlsConfig.ExtendedRandom = true
 	}
@@ -195,12 +232,30 @@ func makeTLSConfig(config *Config, urlHost string) *tls.Config {
 	if config.GatherSessionTicket {
 		tlsConfig.ForceSessionTicketExt = true
 	}
+	if config.ExtendedMasterSecret {
+		tlsConfig.ExtendedMasterSecret = true
+	}
+	if config.EncryptThenMAC {
+		tlsConfig.EncryptThenMAC = true
+	}
+	if config.TLSCertCompression {
+		tlsConfig.CertCompressionAlgorithms = []tls.CertCompressionAlgorithm{tls.CertCompressionZlib}
+	}
+	tlsConfig.ClientHelloPadding = config.TLSClientHelloPadding
+	tlsConfig.ClientHelloMalformation = config.TLSClientHelloMalformation
 	if !config.NoSNI && urlHost != "" {
 		tlsConfig.ServerName = urlHost
 	}
+	if len(config.MultipleSNI) > 0 {
+		tlsConfig.ServerNames = config.MultipleSNI
+	}
+	if config.EmptySNI {
+		tlsConfig.ServerNames = []string{""}
+	}
 	if config.ExternalClientHello != nil {
 		tlsConfig.ExternalClientHello = config.ExternalClientHello
 	}
+	tlsConfig.KeyLogWriter = config.TLSKeyLogWriter
 
 	return tlsConfig
 }
@@ -239,6 +294,11 @@ func redirectsToLocalhost(host string) bool {
 func makeHTTPGrabber(config *Config, grabData *GrabData) func(string, string, string) error {
 	g := func(urlHost, endpoint, httpHost string) (err error) {
 
+		var limit time.Time
+		if config.HTTP.MaxTotalTime > 0 {
+			limit = time.Now().Add(config.HTTP.MaxTotalTime)
+		}
+
 		var tlsConfig *tls.Config
 		if config.TLS {
 			tlsConfig = makeTLSConfig(config, httpHost)
@@ -246,11 +306,14 @@ func makeHTTPGrabber(config *Config, grabData *GrabData) func(string, string, st
 
 		transport := &http.Transport{
 			Proxy:               nil, // TODO: implement proxying
-			Dial:                makeNetDialer(config),
+			Dial:                makeNetDialer(config, limit),
 			DisableKeepAlives:   false,
 			DisableCompression:  false,
 			MaxIdleConnsPerHost: config.HTTP.MaxRedirects,
 			TLSClientConfig:     tlsConfig,
+			RawHeaders:          config.HTTP.RawHeaders,
+			RawChunkedBodySize:  1024 * config.HTTP.MaxSize,
+			PreserveHeaderCase:  config.HTTP.PreserveHeaderCase,
 		}
 
 		client := http.MakeNewClient()
@@ -260,23 +323,14 @@ func makeHTTPGrabber(config *Config, grabData *GrabData) func(string, string, st
 				return ErrRedirLocalhost
 			}
 			grabData.HTTP.RedirectResponseChain = append(grabData.HTTP.RedirectResponseChain, res)
-			b := new(bytes.Buffer)
-			maxReadLen := int64(config.HTTP.MaxSize) * 1024
-			readLen := maxReadLen
-			if res.ContentLength >= 0 && res.ContentLength < maxReadLen {
-				readLen = res.ContentLength
-			}
-			io.CopyN(b, res.Body, readLen)
-			res.BodyText = b.String()
-			if len(res.BodyText) > 0 {
-				m := sha256.New()
-				m.Write(b.Bytes())
-				res.BodySHA256 = m.Sum(nil)
-			}
+			readHTTPBody(config, res)
 
 			if len(via) > config.HTTP.MaxRedirects {
 				return errors.New(fmt.Sprintf("stopped after %d redirects", config.HTTP.MaxRedirects))
 			}
+			if !limit.IsZero() && time.Now().After(limit) {
+				return fmt.Errorf("stopped after %d redirects: exceeded max total time of %s", len(via), config.HTTP.MaxTotalTime)
+			}
 
 			if req.URL.Scheme == "https" && transport.TLSClientConfig == nil {
 				transport.TLSClientConfig = makeTLSConfig(config, req.URL.Host)
@@ -313,25 +367,44 @@ func makeHTTPGrabber(config *Config, grabData *GrabData) func(string, string, st
 			httpHost = hostWithoutPort
 		}
 
-		var req *http.Request
-		var resp *http.Response
-
-		switch config.HTTP.Method {
-		case "GET":
-			req, err = http.NewRequestWithHost("GET", fullURL, httpHost, nil)
-		case "HEAD":
-			req, err = http.NewRequestWithHost("HEAD", fullURL, httpHost, nil)
-		default:
-			zlog.Fatalf("Bad HTTP Method: %s. Valid options are: GET, HEAD.", config.HTTP.Method)
+		newRequest := func(host string) (req *http.Request, err error) {
+			switch config.HTTP.Method {
+			case "GET":
+				req, err = http.NewRequestWithHost("GET", fullURL, host, nil)
+			case "HEAD":
+				req, err = http.NewRequestWithHost("HEAD", fullURL, host, nil)
+			default:
+				zlog.Fatalf("Bad HTTP Method: %s. Valid options are: GET, HEAD.", config.HTTP.Method)
+			}
+			if err == nil {
+				req.Header.Set("Accept", "*/*")
+				if config.HTTP.AcceptEncoding != "" {
+					req.Header.Set("Accept-Encoding", config.HTTP.AcceptEncoding)
+				}
+				if auth := config.HTTP.authorization(); auth != "" {
+					req.Header.Set("Authorization", auth)
+				}
+				if config.HTTP.http10() {
+					req.Protocol = http.Protocol{Name: "HTTP/1.0", Major: 1, Minor: 0}
+					req.Close = true
+				}
+			}
+			return req, err
 		}
+
+		var resp *http.Response
+		req, err := newRequest(httpHost)
 		if err == nil {
-			req.Header.Set("Accept", "*/*")
 			resp, err = client.Do(req)
 		}
 		if resp != nil && resp.Body != nil {
 			defer resp.Body.Close()
 		}
 		grabData.HTTP.Response = resp
+		grabData.IsTLS = resp != nil && resp.TLS != nil
+		if config.HTTP.RedactAuth {
+			grabData.HTTP.redactAuthorization()
+		}
 
 		if err != nil {
 			if urlError, ok := err.(*url.Error); ok {
@@ -342,22 +415,43 @@ func makeHTTPGrabber(config *Config, grabData *GrabData) func(string, string, st
 		}
 
 		if err != nil {
+			// Keep the headers of a response rejected for its framing
+			if urlError, ok := err.(*url.Error); ok {
+				if malformed, ok := urlError.Err.(*http.MalformedResponseError); ok {
+					grabData.HTTP.Response = malformed.Response
+				}
+			}
 			config.ErrorLog.Errorf("Could not connect to remote host %s: %s", fullURL, err.Error())
 			return err
 		}
 
-		b := new(bytes.Buffer)
-		maxReadLen := int64(config.HTTP.MaxSize) * 1024
-		readLen := maxReadLen
-		if resp.ContentLength >= 0 && resp.ContentLength < maxReadLen {
-			readLen = resp.ContentLength
-		}
-		io.CopyN(b, resp.Body, readLen)
-		grabData.HTTP.Response.BodyText = b.String()
-		if len(grabData.HTTP.Response.BodyText) > 0 {
-			m := sha256.New()
-			m.Write(b.Bytes())
-			grabData.HTTP.Response.BodySHA256 = m.Sum(nil)
+		readHTTPBody(config, resp)
+		grabData.HTTP.classifyExposure()
+
+		if config.HTTP.ProbeDefaultVhost {
+			// Record the default vhost's own response, not where it redirects
+			client.CheckRedirect = func(*http.Request, *http.Response, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+			// The real response is already recorded, so a failed probe
+			// is logged with the grab rather than failing it
+			probe, err := newRequest(defaultVhostProbeHost)
+			if err != nil {
+				grabData.HTTP.DefaultVhostError = err.Error()
+				return nil
+			}
+			probeResp, err := client.Do(probe)
+			if err != nil {
+				config.ErrorLog.Errorf("Default vhost probe of %s failed: %s", fullURL, err.Error())
+				grabData.HTTP.DefaultVhostError = err.Error()
+				return nil
+			}
+			defer probeResp.Body.Close()
+			readHTTPBody(config, probeResp)
+			grabData.HTTP.DefaultVhostResponse = probeResp
+			if config.HTTP.RedactAuth {
+				grabData.HTTP.redactAuthorization()
+			}
 		}
 
 		return nil
@@ -366,9 +460,48 @@ func makeHTTPGrabber(config *Config, grabData *GrabData) func(string, string, st
 	return g
 }
 
+// defaultVhostProbeHost is the Host header sent by the default vhost probe.
+// The .invalid TLD is reserved, so no server is configured for it.
+const defaultVhostProbeHost = "zgrab-default-vhost.invalid"
+
+// readHTTPBody reads up to HTTP.MaxSize KB of res's body and records it in
+// res.
+func readHTTPBody(config *Config, res *http.Response) {
+	b := new(bytes.Buffer)
+	maxReadLen := int64(config.HTTP.MaxSize) * 1024
+	readLen := maxReadLen
+	if res.ContentLength >= 0 && res.ContentLength < maxReadLen {
+		readLen = res.ContentLength
+	}
+	if _, err := io.CopyN(b, res.Body, readLen); err != nil && err != io.EOF {
+		// Keep whatever the server sent rather than a partly decoded body
+		if raw := res.RawChunkedBody(); raw != nil {
+			b.Reset()
+			b.Write(raw)
+			res.ChunkedDecodeFailed = true
+			res.ChunkedDecodeError = err.Error()
+		}
+	}
+	res.BodyText = b.String()
+	if config.HTTP.DecodeCharset {
+		res.BodyUTF8, _ = decodeBodyToUTF8(b.Bytes(), res.Header.Get("Content-Type"))
+		res.BodyCharsetUnsupported = unsupportedCharset(b.Bytes(), res.Header.Get("Content-Type"))
+	}
+	if len(res.BodyText) > 0 {
+		m := sha256.New()
+		m.Write(b.Bytes())
+		res.BodySHA256 = m.Sum(nil)
+	}
+}
+
 func makeGrabber(config *Config) func(*Conn) error {
 	// Do all the hard work here
 	g := func(c *Conn) error {
+		c.SetRawBannerCapture(config.RawBannerSize)
+		if config.ConnectOnly {
+			c.ConnectOnly()
+			return nil
+		}
 		banner := make([]byte, 1024)
 		response := make([]byte, 65536)
 		c.SetCAPool(config.RootCAPool)
@@ -404,9 +537,19 @@ func makeGrabber(config *Config) func(*Conn) error {
 			c.CipherSuites = tls.SafariNoDHECiphers
 			c.ForceSuites = true
 		}
+		if config.TLSForceCipher != 0 {
+			c.ForceCipher(config.TLSForceCipher)
+		}
+		if len(config.TLSCurves) > 0 {
+			c.SetSupportedCurves(config.TLSCurves)
+		}
 		if config.NoSNI {
 			c.SetNoSNI()
 		}
+		if len(config.MultipleSNI) > 0 {
+			c.SetMultipleSNI(config.MultipleSNI)
+		}
+		c.SetEmptySNI(config.EmptySNI)
 		if config.TLSExtendedRandom {
 			c.SetExtendedRandom()
 		}
@@ -419,21 +562,101 @@ func makeGrabber(config *Config) func(*Conn) error {
 		if config.ExtendedMasterSecret {
 			c.SetOfferExtendedMasterSecret()
 		}
+		if config.EncryptThenMAC {
+			c.SetOfferEncryptThenMAC()
+		}
+		if config.TLSClientHelloPadding {
+			c.SetClientHelloPadding()
+		}
+		if config.TLSALPS && (config.ChromeOnly || config.ChromeNoDHE) {
+			c.SetOfferALPS()
+		}
+		if config.TLSCertCompression {
+			c.SetOfferCertCompression()
+		}
+		if config.TLSClientHelloMalformation != "" {
+			c.SetClientHelloMalformation(config.TLSClientHelloMalformation)
+		}
 		if config.ExternalClientHello != nil {
 			c.SetExternalClientHello(config.ExternalClientHello)
 		}
+		if config.TLSKeyLogWriter != nil {
+			c.SetKeyLogWriter(config.TLSKeyLogWriter)
+		}
 		if config.TLSVerbose {
 			c.SetTLSVerbose()
 		}
 		if config.TLSCertsOnly {
 			c.SetTLSCertsOnly()
 		}
+		c.SetMaxCertChainLength(config.TLSMaxCertChainLength)
+		if config.TLSLimitCertParsing {
+			c.SetParseCertLimit(config.TLSParseCertLimit)
+		}
+		c.SetFallbackSCSV(config.TLSFallbackSCSV)
+		c.SetMaxFragmentLength(config.TLSMaxFragmentLength)
+		c.SetClientHelloRecordVersion(config.TLSClientHelloRecordVersion)
+		c.SetImplicitTLS(config.ImplicitTLS)
+		c.SetExpectProactiveBanner(config.ProactiveBannerTimeout)
+		c.SetSMTPGreetingWait(config.SMTPGreetingWait)
+		if config.SMTPPipelining {
+			c.SetSMTPPipeliningKnown()
+		}
+		if config.AutoProbe {
+			if err := c.AutoProbe(); err != nil {
+				c.erroredComponent = "auto_probe"
+				return err
+			}
+			return nil
+		}
+		if config.TLSVersionIntolerance {
+			if err := c.CheckVersionIntolerance(); err != nil {
+				c.erroredComponent = "tls_version_intolerance"
+				return err
+			}
+		}
+		if config.TLSCipherPreference {
+			if err := c.CheckServerCipherPreference(); err != nil {
+				c.erroredComponent = "tls_cipher_preference"
+				return err
+			}
+		}
+		if config.TLSPaddingProbe {
+			if err := c.CheckClientHelloPadding(); err != nil {
+				c.erroredComponent = "tls_padding_probe"
+				return err
+			}
+		}
+		if config.TLSProfile {
+			if err := c.FullTLSProfile(config.TLSProfileMaxHandshakes); err != nil {
+				c.erroredComponent = "tls_profile"
+				return err
+			}
+		}
+		if config.TLSTicketKeyReuse > 0 {
+			if err := c.CheckTicketKeyReuse(config.TLSTicketKeyReuse); err != nil {
+				c.erroredComponent = "tls_ticket_key_reuse"
+				return err
+			}
+		}
 		if config.TLS {
 			if err := c.TLSHandshake(); err != nil {
 				c.erroredComponent = "tls"
 				return err
 			}
 		}
+		if config.TLSNested {
+			if err := c.TLSHandshakeNested(); err != nil {
+				c.erroredComponent = "nested_tls"
+				return err
+			}
+		}
+		if config.ProactiveBannerTimeout > 0 && !config.Banners {
+			if err := c.ReadProactiveBanner(); err != nil {
+				c.erroredComponent = "banner"
+				return err
+			}
+		}
 		if config.Banners {
 			if config.SMTP {
 				if _, err := c.SMTPBanner(banner); err != nil {
@@ -450,6 +673,11 @@ func makeGrabber(config *Config) func(*Conn) error {
 					c.erroredComponent = "banner"
 					return err
 				}
+			} else if config.BannerLines > 0 {
+				if _, err := c.LineBanner(response, config.BannerLines); err != nil {
+					c.erroredComponent = "banner"
+					return err
+				}
 			} else {
 				if _, err := c.BasicBanner(); err != nil {
 					c.erroredComponent = "banner"
@@ -472,6 +700,12 @@ func makeGrabber(config *Config) func(*Conn) error {
 					c.erroredComponent = "ftp-authtls"
 					return err
 				}
+				if config.FTPProt && c.grabData.IsTLS {
+					if err := c.FTPDataProtection(); err != nil {
+						c.erroredComponent = "ftp-prot"
+						return err
+					}
+				}
 			}
 		}
 
@@ -493,6 +727,49 @@ func makeGrabber(config *Config) func(*Conn) error {
 			}
 		}
 
+		if config.WhoisQuery != "" {
+			if err := c.WhoisQuery(config.WhoisQuery, config.WhoisMaxSize); err != nil {
+				c.erroredComponent = "whois"
+				return err
+			}
+		}
+
+		if config.MQTT {
+			c.SetMQTTProtocolLevel(config.MQTTProtocolLevel)
+			if err := c.MQTTProbe(); err != nil {
+				c.erroredComponent = "mqtt"
+				return err
+			}
+		}
+
+		if config.SIP {
+			if err := c.SIPProbe(); err != nil {
+				c.erroredComponent = "sip"
+				return err
+			}
+		}
+
+		if config.Finger {
+			if err := c.FingerProbe(config.FingerQuery); err != nil {
+				c.erroredComponent = "finger"
+				return err
+			}
+		}
+
+		if config.Gopher {
+			if err := c.GopherProbe(config.GopherSelector); err != nil {
+				c.erroredComponent = "gopher"
+				return err
+			}
+		}
+
+		if config.IRC {
+			if err := c.IRCProbe(config.IRCNick); err != nil {
+				c.erroredComponent = "irc"
+				return err
+			}
+		}
+
 		if config.S7 {
 			c.grabData.S7 = new(siemens.S7Log)
 
@@ -519,22 +796,28 @@ func makeGrabber(config *Config) func(*Conn) error {
 		if config.SendData {
 			host, _, _ := net.SplitHostPort(c.RemoteAddr().String())
 			msg := bytes.Replace(config.Data, []byte("%s"), []byte(host), -1)
-			msg = bytes.Replace(msg, []byte("%d"), []byte(c.domain), -1)
+			msg = bytes.Replace(msg, []byte("%d"), []byte(c.Domain), -1)
 			if _, err := c.Write(msg); err != nil {
 				c.erroredComponent = "write"
 				return err
 			}
-			if _, err := c.Read(response); err != nil {
+			if config.HalfClose {
+				if _, err := c.HalfClose(response); err != nil {
+					c.erroredComponent = "half_close"
+					return err
+				}
+			} else if _, err := c.Read(response); err != nil {
 				c.erroredComponent = "read"
 				return err
 			}
 		}
 
-		if config.EHLO {
+		if config.EHLO && !config.SMTPPipelining {
 			if err := c.EHLO(config.EHLODomain); err != nil {
 				c.erroredComponent = "ehlo"
 				return err
 			}
+			c.CheckSTARTTLSStripping()
 		}
 		if config.SMTPHelp {
 			if err := c.SMTPHelp(); err != nil {
@@ -542,17 +825,59 @@ func makeGrabber(config *Config) func(*Conn) error {
 				return err
 			}
 		}
+		if config.SMTPVrfy != "" {
+			if err := c.SMTPVrfy(config.SMTPVrfy); err != nil {
+				c.erroredComponent = "smtp_vrfy"
+				return err
+			}
+		}
+		if config.SMTPExpn != "" {
+			if err := c.SMTPExpn(config.SMTPExpn); err != nil {
+				c.erroredComponent = "smtp_expn"
+				return err
+			}
+		}
+		if config.IMAPID {
+			if err := c.IMAPID(); err != nil {
+				c.erroredComponent = "imap_id"
+				return err
+			}
+		}
+		if config.IMAPCapability {
+			if err := c.IMAPCapability(); err != nil {
+				c.erroredComponent = "imap_capability"
+				return err
+			}
+		}
 		if config.StartTLS {
 			if config.IMAP {
 				if err := c.IMAPStartTLSHandshake(); err != nil {
 					c.erroredComponent = "starttls"
 					return err
 				}
+				if config.IMAPCapability {
+					if err := c.IMAPCapability(); err != nil {
+						c.erroredComponent = "imap_capability"
+						return err
+					}
+				}
 			} else if config.POP3 {
 				if err := c.POP3StartTLSHandshake(); err != nil {
 					c.erroredComponent = "starttls"
 					return err
 				}
+			} else if config.SMTPPipelining {
+				err := c.SMTPPipelinedStartTLSHandshake(config.EHLODomain)
+				c.CheckSTARTTLSStripping()
+				if err != nil {
+					c.erroredComponent = "starttls"
+					return err
+				}
+			} else if config.SMTPStartTLSInjection {
+				if err := c.SMTPStartTLSInjectionTest(); err != nil {
+					c.erroredComponent = "starttls_injection"
+					return err
+				}
 			} else {
 				if err := c.SMTPStartTLSHandshake(); err != nil {
 					c.erroredComponent = "starttls"
@@ -578,6 +903,27 @@ func makeGrabber(config *Config) func(*Conn) error {
 			}
 		}
 
+		if len(config.HTTPPipeline) > 0 {
+			configs := make([]*HTTPConfig, len(config.HTTPPipeline))
+			for i, endpoint := range config.HTTPPipeline {
+				httpConfig := config.HTTP
+				httpConfig.Endpoint = endpoint
+				configs[i] = &httpConfig
+			}
+			if err := c.HTTPMulti(configs); err != nil {
+				c.erroredComponent = "http_pipeline"
+				return err
+			}
+		}
+
+		if config.HTTPWellKnown {
+			c.SetWellKnownPaths(config.HTTPWellKnownPaths)
+			if err := c.ProbeWellKnownPaths(&config.HTTP); err != nil {
+				c.erroredComponent = "http_well_known"
+				return err
+			}
+		}
+
 		if config.Modbus {
 			if _, err := c.SendModbusEcho(); err != nil {
 				c.erroredComponent = "modbus"
@@ -642,6 +988,23 @@ func GrabBanner(config *Config, target *GrabTarget) *Grab {
 		}
 	}()
 
+	grab := grabBanner(config, target)
+	if config.MaxAttempts > 1 {
+		attempts := uint(1)
+		for attempts < config.MaxAttempts && isTransientError(grab.Error) {
+			time.Sleep(retryBackoff(config.RetryBackoff, attempts))
+			attempts++
+			grab = grabBanner(config, target)
+		}
+		grab.Data.Attempts = attempts
+	}
+	if config.TLSFlat {
+		grab.Data.FlattenTLS()
+	}
+	return grab
+}
+
+func grabBanner(config *Config, target *GrabTarget) *Grab {
 	if config.XSSH.XSSH {
 		t := time.Now()
 
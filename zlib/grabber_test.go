@@ -12,6 +12,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -104,6 +105,258 @@ func getAddrAndPortForServer(s *httptest.Server) (net.IP, uint16) {
 	return addr, port
 }
 
+func TestHTTPProbeDefaultVhost(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Host == "localhost" {
+			fmt.Fprintf(w, TEST_SERVER_BODY)
+			return
+		}
+		Redirect(w, r, "http://localhost/", StatusFound)
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := &zlib.Config{
+		Port:               port,
+		Timeout:            time.Duration(3) * time.Second,
+		TLSVersion:         tls.VersionTLS12,
+		Senders:            1,
+		ConnectionsPerHost: 1,
+		HTTP: zlib.HTTPConfig{
+			Endpoint:          "/",
+			Method:            "GET",
+			UserAgent:         "test UA",
+			MaxSize:           256,
+			ProbeDefaultVhost: true,
+		},
+		ErrorLog:   zlog.New(os.Stderr, "banner-grab"),
+		GOMAXPROCS: 1,
+	}
+
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr, Domain: "localhost"})
+	if grab.Error != nil {
+		t.Fatalf("Grab failed: %s", grab.Error)
+	}
+	if body := grab.Data.HTTP.Response.BodyText; body != TEST_SERVER_BODY {
+		t.Errorf("Unexpected HTTP response body: %q", body)
+	}
+	defaultVhost := grab.Data.HTTP.DefaultVhostResponse
+	if defaultVhost == nil || defaultVhost.StatusCode != StatusFound {
+		t.Fatalf("Expected a %d default vhost response, got %+v", StatusFound, defaultVhost)
+	}
+	if host := defaultVhost.Request.Host; host == "localhost" {
+		t.Errorf("Default vhost probe sent the real Host header")
+	}
+}
+
+func TestHTTPProbeDefaultVhostFailed(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Host == "localhost" {
+			fmt.Fprintf(w, TEST_SERVER_BODY)
+			return
+		}
+		conn, _, err := w.(Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := &zlib.Config{
+		Port:               port,
+		Timeout:            time.Duration(3) * time.Second,
+		TLSVersion:         tls.VersionTLS12,
+		Senders:            1,
+		ConnectionsPerHost: 1,
+		HTTP: zlib.HTTPConfig{
+			Endpoint:          "/",
+			Method:            "GET",
+			UserAgent:         "test UA",
+			MaxSize:           256,
+			ProbeDefaultVhost: true,
+		},
+		ErrorLog:   zlog.New(os.Stderr, "banner-grab"),
+		GOMAXPROCS: 1,
+	}
+
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr, Domain: "localhost"})
+	if grab.Error != nil {
+		t.Fatalf("Failed default vhost probe failed the grab: %s", grab.Error)
+	}
+	if body := grab.Data.HTTP.Response.BodyText; body != TEST_SERVER_BODY {
+		t.Errorf("Unexpected HTTP response body: %q", body)
+	}
+	if grab.Data.HTTP.DefaultVhostResponse != nil || grab.Data.HTTP.DefaultVhostError == "" {
+		t.Errorf("Probe failure not recorded: %+v", grab.Data.HTTP)
+	}
+}
+
+func TestHTTPMalformedChunkedBody(t *testing.T) {
+	const raw = "3\r\none\r\nzz\r\nbad\r\n"
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		conn, buf, err := w.(Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		buf.WriteString("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + raw)
+		buf.Flush()
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := &zlib.Config{
+		Port:               port,
+		Timeout:            time.Duration(3) * time.Second,
+		TLSVersion:         tls.VersionTLS12,
+		Senders:            1,
+		ConnectionsPerHost: 1,
+		HTTP: zlib.HTTPConfig{
+			Endpoint:  "/",
+			Method:    "GET",
+			UserAgent: "test UA",
+			MaxSize:   256,
+		},
+		ErrorLog:   zlog.New(os.Stderr, "banner-grab"),
+		GOMAXPROCS: 1,
+	}
+
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr, Domain: "localhost"})
+	if grab.Error != nil {
+		t.Fatalf("Grab failed: %s", grab.Error)
+	}
+	res := grab.Data.HTTP.Response
+	if !res.ChunkedDecodeFailed || res.ChunkedDecodeError == "" {
+		t.Errorf("Decode failure not recorded: %v, %q", res.ChunkedDecodeFailed, res.ChunkedDecodeError)
+	}
+	if res.BodyText != raw {
+		t.Errorf("Wrong raw body - expected: %q, got: %q", raw, res.BodyText)
+	}
+}
+
+func TestHTTPGrabSmugglingIndicators(t *testing.T) {
+	tests := []struct {
+		response  string
+		indicator string
+		fails     bool
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 2\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n",
+			zlib.HTTPSmugglingContentLengthAndTransferEncoding, false},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Length: 3\r\n\r\nok",
+			zlib.HTTPSmugglingConflictingContentLength, true},
+	}
+	for _, test := range tests {
+		ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+			conn, buf, err := w.(Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			buf.WriteString(test.response)
+			buf.Flush()
+			conn.Close()
+		}))
+
+		addr, port := getAddrAndPortForServer(ts)
+		config := &zlib.Config{
+			Port:               port,
+			Timeout:            time.Duration(3) * time.Second,
+			TLSVersion:         tls.VersionTLS12,
+			Senders:            1,
+			ConnectionsPerHost: 1,
+			HTTP: zlib.HTTPConfig{
+				Endpoint:  "/",
+				Method:    "GET",
+				UserAgent: "test UA",
+				MaxSize:   256,
+			},
+			ErrorLog:   zlog.New(os.Stderr, "banner-grab"),
+			GOMAXPROCS: 1,
+		}
+
+		grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr, Domain: "localhost"})
+		ts.Close()
+		if failed := grab.Error != nil; failed != test.fails {
+			t.Errorf("Grab error for %q: %v", test.response, grab.Error)
+		}
+		res := grab.Data.HTTP.Response
+		if res == nil || len(res.SmugglingIndicators) != 1 || res.SmugglingIndicators[0] != test.indicator {
+			t.Errorf("Wrong indicators for %q: %+v", test.response, res)
+		}
+	}
+}
+
+func TestHTTPExposedEnv(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprint(w, "# production\nDB_PASSWORD=hunter2\nexport APP_KEY=abc\n")
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := &zlib.Config{
+		Port:               port,
+		Timeout:            time.Duration(3) * time.Second,
+		TLSVersion:         tls.VersionTLS12,
+		Senders:            1,
+		ConnectionsPerHost: 1,
+		HTTP: zlib.HTTPConfig{
+			Endpoint:  "/.env",
+			Method:    "GET",
+			UserAgent: "test UA",
+			MaxSize:   256,
+		},
+		ErrorLog:   zlog.New(os.Stderr, "banner-grab"),
+		GOMAXPROCS: 1,
+	}
+
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr})
+	if grab.Error != nil {
+		t.Fatalf("Grab failed: %s", grab.Error)
+	}
+	if !grab.Data.HTTP.ExposedEnv {
+		t.Errorf("Dotenv response was not flagged as exposed")
+	}
+	if grab.Data.HTTP.ExposedGitHead {
+		t.Errorf("Dotenv response was flagged as an exposed git HEAD")
+	}
+}
+
+func TestHTTPProtocolVersion10(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Protocol.Name != "HTTP/1.0" {
+			t.Errorf("Wrong protocol - expected: HTTP/1.0, got: %s", r.Protocol.Name)
+		}
+		fmt.Fprintf(w, TEST_SERVER_BODY)
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := &zlib.Config{
+		Port:               port,
+		Timeout:            time.Duration(3) * time.Second,
+		TLSVersion:         tls.VersionTLS12,
+		Senders:            1,
+		ConnectionsPerHost: 1,
+		HTTP: zlib.HTTPConfig{
+			Endpoint:        "/",
+			Method:          "GET",
+			UserAgent:       "test UA",
+			MaxSize:         256,
+			ProtocolVersion: "HTTP/1.0",
+		},
+		ErrorLog:   zlog.New(os.Stderr, "banner-grab"),
+		GOMAXPROCS: 1,
+	}
+
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr})
+	if grab.Error != nil {
+		t.Fatalf("Grab failed: %s", grab.Error)
+	}
+	if body := grab.Data.HTTP.Response.BodyText; body != TEST_SERVER_BODY {
+		t.Errorf("Unexpected HTTP response body: %q", body)
+	}
+}
+
 func TestHTTPToHTTPSRedirect(t *testing.T) {
 
 	var tlsServerHostString string
@@ -178,3 +431,50 @@ func TestHTTPToHTTPSRedirect(t *testing.T) {
 }
 
 // TODO: add tests for more complex HTTP behavior/options
+
+func TestHTTPRetriesReset(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			conn, _, err := w.(Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+			return
+		}
+		fmt.Fprintf(w, TEST_SERVER_BODY)
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := &zlib.Config{
+		Port:               port,
+		Timeout:            time.Duration(3) * time.Second,
+		TLSVersion:         tls.VersionTLS12,
+		Senders:            1,
+		ConnectionsPerHost: 1,
+		MaxAttempts:        2,
+		RetryBackoff:       time.Millisecond,
+		HTTP: zlib.HTTPConfig{
+			Endpoint:  "/",
+			Method:    "GET",
+			UserAgent: "test UA",
+			MaxSize:   256,
+		},
+		ErrorLog:   zlog.New(os.Stderr, "banner-grab"),
+		GOMAXPROCS: 1,
+	}
+
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr, Domain: "localhost"})
+	if grab.Error != nil {
+		t.Fatalf("Grab failed: %s", grab.Error)
+	}
+	if grab.Data.Attempts != 2 {
+		t.Errorf("Reset was not retried: %d attempts", grab.Data.Attempts)
+	}
+	if grab.Data.HTTP.Response.BodyText != TEST_SERVER_BODY {
+		t.Errorf("Unexpected HTTP response body")
+	}
+}
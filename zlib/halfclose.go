@@ -0,0 +1,76 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Outcomes of reading after a half-close, see HalfCloseLog
+const (
+	HalfCloseOutcomeClosed  = "closed"
+	HalfCloseOutcomeReset   = "reset"
+	HalfCloseOutcomeTimeout = "timeout"
+	HalfCloseOutcomeFull    = "buffer_full"
+	HalfCloseOutcomeError   = "error"
+)
+
+// HalfCloseLog records how the server behaved after we closed our side of
+// the connection for writing. Response holds everything read afterwards.
+type HalfCloseLog struct {
+	Response string `json:"response,omitempty"`
+	Outcome  string `json:"outcome"`
+}
+
+// CloseWrite shuts down the sending side of the underlying TCP connection.
+// On a TLS connection no close_notify is sent first. It fails on connections
+// that are not TCP.
+func (c *Conn) CloseWrite() error {
+	tcp, ok := c.tcpConn()
+	if !ok {
+		return errors.New("half-close requires a TCP connection")
+	}
+	return tcp.CloseWrite()
+}
+
+// HalfClose calls CloseWrite and reads into b until the server closes or
+// resets the connection, the read deadline passes or b is full. The outcome
+// is recorded in HalfClose of the grab data; only a failed CloseWrite is
+// returned as an error.
+func (c *Conn) HalfClose(b []byte) (int, error) {
+	if err := c.CloseWrite(); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(c.getUnderlyingConn(), b)
+	log := &HalfCloseLog{Response: string(b[:n])}
+	netErr, isNetErr := err.(net.Error)
+	switch {
+	case err == nil:
+		log.Outcome = HalfCloseOutcomeFull
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		log.Outcome = HalfCloseOutcomeClosed
+	case rootCause(err) == syscall.ECONNRESET:
+		log.Outcome = HalfCloseOutcomeReset
+	case isNetErr && netErr.Timeout():
+		log.Outcome = HalfCloseOutcomeTimeout
+	default:
+		log.Outcome = HalfCloseOutcomeError
+	}
+	c.grabData.HalfClose = log
+	return n, nil
+}
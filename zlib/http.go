@@ -15,6 +15,10 @@
 package zlib
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
 	"strings"
 
 	"github.com/zmap/zgrab/ztools/http"
@@ -58,21 +62,212 @@ func HeadersFromGolangHeaders(h http.Header) HTTPHeaders {
 	return out
 }
 
+const redactedAuthorization = "<redacted>"
+
+// authorization returns the Authorization header value described by the
+// config, or the empty string if no credentials are configured. A bearer
+// token takes precedence over basic credentials.
+func (config *HTTPConfig) authorization() string {
+	if config.BearerToken != "" {
+		return "Bearer " + config.BearerToken
+	}
+	if config.BasicAuthUser != "" || config.BasicAuthPassword != "" {
+		creds := config.BasicAuthUser + ":" + config.BasicAuthPassword
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+	}
+	return ""
+}
+
+// http10 reports whether requests should be sent as HTTP/1.0 rather than
+// HTTP/1.1.
+func (config *HTTPConfig) http10() bool {
+	return config.ProtocolVersion == "HTTP/1.0"
+}
+
+// recordedAuthorization returns the Authorization value as it should appear
+// in output.
+func (config *HTTPConfig) recordedAuthorization() string {
+	auth := config.authorization()
+	if auth != "" && config.RedactAuth {
+		return redactedAuthorization
+	}
+	return auth
+}
+
+// redactAuthorization replaces the Authorization header on every request in
+// the redirect chain so credentials are not written to the output.
+func (h *HTTP) redactAuthorization() {
+	responses := append([]*http.Response{h.Response, h.DefaultVhostResponse}, h.RedirectResponseChain...)
+	for _, res := range responses {
+		if res == nil || res.Request == nil {
+			continue
+		}
+		if res.Request.Header.Get("Authorization") != "" {
+			res.Request.Header.Set("Authorization", redactedAuthorization)
+		}
+	}
+}
+
 type HTTPRequest struct {
-	Method    string `json:"method,omitempty"`
-	Endpoint  string `json:"endpoint,omitempty"`
-	UserAgent string `json:"user_agent,omitempty"`
-	Body      string `json:"body,omitempty"`
+	Method        string `json:"method,omitempty"`
+	Endpoint      string `json:"endpoint,omitempty"`
+	UserAgent     string `json:"user_agent,omitempty"`
+	Authorization string `json:"authorization,omitempty"`
+	Body          string `json:"body,omitempty"`
+	Version       string `json:"version,omitempty"`
 }
 
+// How the end of an HTTP response body is determined
+const (
+	HTTPFramingNone           = "none"
+	HTTPFramingContentLength  = "content-length"
+	HTTPFramingChunked        = "chunked"
+	HTTPFramingCloseDelimited = "close-delimited"
+)
+
+// Request smuggling preconditions found in a response's raw headers
+const (
+	HTTPSmugglingContentLengthAndTransferEncoding = http.SmugglingContentLengthAndTransferEncoding
+	HTTPSmugglingConflictingContentLength         = http.SmugglingConflictingContentLength
+)
+
 type HTTPResponse struct {
-	VersionMajor int                  `json:"version_major,omitempty"`
-	VersionMinor int                  `json:"version_minor,omitempty"`
-	StatusCode   int                  `json:"status_code,omitempty"`
-	StatusLine   string               `json:"status_line,omitempty"`
-	Headers      HTTPHeaders          `json:"headers,omitempty"`
-	Body         string               `json:"body,omitempty"`
-	BodySHA256   http.PageFingerprint `json:"body_sha256,omitempty"`
+	VersionMajor  int                  `json:"version_major,omitempty"`
+	VersionMinor  int                  `json:"version_minor,omitempty"`
+	StatusCode    int                  `json:"status_code,omitempty"`
+	StatusLine    string               `json:"status_line,omitempty"`
+	Headers       HTTPHeaders          `json:"headers,omitempty"`
+	Body          string               `json:"body,omitempty"`
+	BodyUTF8      string               `json:"body_utf8,omitempty"`
+	BodyTruncated bool                 `json:"body_truncated,omitempty"`
+	BodySHA256    http.PageFingerprint `json:"body_sha256,omitempty"`
+
+	// BodyCharsetUnsupported is the declared charset when DecodeCharset is
+	// set but it is not one decodeBodyToUTF8 can decode to BodyUTF8
+	BodyCharsetUnsupported string `json:"body_charset_unsupported,omitempty"`
+
+	// BodyFraming is one of the HTTPFraming constants. A content-length
+	// body cut short by the server closing the connection is kept and
+	// marked BodyTruncated.
+	BodyFraming string `json:"body_framing,omitempty"`
+
+	// ChunkedDecodeFailed is set when the chunked body could not be decoded,
+	// in which case Body holds the raw, still-chunked bytes and
+	// ChunkedDecodeError the reason.
+	ChunkedDecodeFailed bool   `json:"chunked_decode_failed,omitempty"`
+	ChunkedDecodeError  string `json:"chunked_decode_error,omitempty"`
+
+	SecurityHeaders *http.SecurityHeaders `json:"security_headers,omitempty"`
+
+	// RawHeaders is the status line and header block exactly as received.
+	// See HTTPConfig.RawHeaders.
+	RawHeaders string `json:"raw_headers,omitempty"`
+
+	// OrderedHeaders lists the headers as received, see
+	// HTTPConfig.PreserveHeaderCase.
+	OrderedHeaders []http.HeaderField `json:"ordered_headers,omitempty"`
+
+	// InterimResponses holds the 1xx responses, such as 100 Continue,
+	// received before this one, in order.
+	InterimResponses []*HTTPResponse `json:"interim_responses,omitempty"`
+
+	// TimeToFirstByteMicros is the time from finishing the request write
+	// to receiving the first byte of the response, interim ones included.
+	TimeToFirstByteMicros int64 `json:"time_to_first_byte_us,omitempty"`
+
+	// SmugglingIndicators lists the HTTPSmuggling constants that apply to
+	// the raw headers, which net/http would otherwise normalize or reject
+	SmugglingIndicators []string `json:"smuggling_indicators,omitempty"`
+}
+
+// headerRecorder passes reads through from r and, between start and stop,
+// keeps a copy of what was read. The net/http response reader can't keep the
+// raw header block itself, unlike ztools/http.ReadResponseRawHeaders.
+type headerRecorder struct {
+	r         io.Reader
+	recording bool
+	buf       bytes.Buffer
+}
+
+func (rec *headerRecorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	if rec.recording {
+		rec.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// start begins a new recording, seeded with whatever br has buffered but not
+// yet returned, e.g. the start of a pipelined response.
+func (rec *headerRecorder) start(br *bufio.Reader) {
+	rec.buf.Reset()
+	buffered, _ := br.Peek(br.Buffered())
+	rec.buf.Write(buffered)
+	rec.recording = true
+}
+
+// stop ends recording and returns the bytes br has consumed since start,
+// which right after http.ReadResponse are the status line and headers.
+func (rec *headerRecorder) stop(br *bufio.Reader) []byte {
+	rec.recording = false
+	b := rec.buf.Bytes()
+	return b[:len(b)-br.Buffered()]
+}
+
+// rawBodyRecorder passes reads through from r and, once started, keeps a
+// copy of up to max bytes of what was read.
+type rawBodyRecorder struct {
+	r         io.Reader
+	max       int
+	recording bool
+	buf       bytes.Buffer
+}
+
+// reset discards any recording and sets the limit for the next one.
+func (rec *rawBodyRecorder) reset(max int) {
+	rec.buf.Reset()
+	rec.max = max
+	rec.recording = false
+}
+
+// start begins recording, seeding the copy with whatever br has already
+// buffered past the response headers.
+func (rec *rawBodyRecorder) start(br *bufio.Reader) {
+	buffered, _ := br.Peek(br.Buffered())
+	rec.record(buffered)
+	rec.recording = true
+}
+
+func (rec *rawBodyRecorder) record(b []byte) {
+	if room := rec.max - rec.buf.Len(); room > 0 {
+		if len(b) > room {
+			b = b[:room]
+		}
+		rec.buf.Write(b)
+	}
+}
+
+func (rec *rawBodyRecorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	if rec.recording {
+		rec.record(p[:n])
+	}
+	return n, err
+}
+
+// Bytes returns the recorded bytes.
+func (rec *rawBodyRecorder) Bytes() []byte {
+	return rec.buf.Bytes()
+}
+
+// An HTTPExchange is one request and its response from Conn.HTTPMulti
+type HTTPExchange struct {
+	Request  *HTTPRequest  `json:"request,omitempty"`
+	Response *HTTPResponse `json:"response,omitempty"`
+
+	// Reconnected is set on the first request sent on a new connection
+	// after the server closed the previous one.
+	Reconnected bool `json:"reconnected,omitempty"`
 }
 
 type HTTP struct {
@@ -80,6 +275,19 @@ type HTTP struct {
 	ProxyResponse         *HTTPResponse    `json:"connect_response,omitempty"`
 	Response              *http.Response   `json:"response,omitempty"`
 	RedirectResponseChain []*http.Response `json:"redirect_response_chain,omitempty"`
+
+	// DefaultVhostResponse is the response to a request with a Host header
+	// no server is configured for, see HTTPConfig.ProbeDefaultVhost.
+	DefaultVhostResponse *http.Response `json:"default_vhost_response,omitempty"`
+	DefaultVhostError    string         `json:"default_vhost_error,omitempty"`
+
+	// Pipelined holds the exchanges from Conn.HTTPMulti, in request order
+	Pipelined []*HTTPExchange `json:"pipelined,omitempty"`
+
+	// ExposedGitHead and ExposedEnv are set when Response is for a
+	// /.git/HEAD or /.env that holds the real file rather than an error page
+	ExposedGitHead bool `json:"exposed_git_head,omitempty"`
+	ExposedEnv     bool `json:"exposed_env,omitempty"`
 }
 
 func init() {
@@ -0,0 +1,118 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"strings"
+)
+
+// ircMaxResponseSize bounds how much of the registration exchange is read
+const ircMaxResponseSize = 65536
+
+// ircMaxLineSize bounds a single line: 512 bytes (RFC 1459) plus room for
+// IRCv3 message tags
+const ircMaxLineSize = 8192 + 512
+
+// An IRCLog holds what an IRC server revealed during registration
+type IRCLog struct {
+	ServerName    string   `json:"server_name,omitempty"`
+	Version       string   `json:"version,omitempty"`
+	Welcome       string   `json:"welcome,omitempty"`
+	ISupport      []string `json:"isupport,omitempty"`
+	Capabilities  []string `json:"capabilities,omitempty"`
+	TLSCapability bool     `json:"tls_capability"`
+	Raw           string   `json:"raw,omitempty"`
+	LineTruncated bool     `json:"line_truncated,omitempty"`
+}
+
+// An ircMessage is a single parsed IRC protocol line (RFC 1459 section 2.3.1)
+type ircMessage struct {
+	prefix  string
+	command string
+	params  []string
+}
+
+func parseIRCMessage(line string) ircMessage {
+	var m ircMessage
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			m.prefix = line[1:]
+			return m
+		}
+		m.prefix = line[1:i]
+		line = line[i+1:]
+	}
+	for len(line) > 0 {
+		line = strings.TrimLeft(line, " ")
+		if strings.HasPrefix(line, ":") {
+			m.params = append(m.params, line[1:])
+			break
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			i = len(line)
+		}
+		if m.command == "" {
+			m.command = strings.ToUpper(line[:i])
+		} else if i > 0 {
+			m.params = append(m.params, line[:i])
+		}
+		line = line[i:]
+	}
+	return m
+}
+
+// handle records the contents of m in the log. It returns true once the
+// server has finished registration (end of MOTD) or refused it.
+func (l *IRCLog) handle(m ircMessage) bool {
+	switch m.command {
+	case "001":
+		if len(m.params) > 0 {
+			l.Welcome = m.params[len(m.params)-1]
+		}
+	case "004":
+		if len(m.params) >= 3 {
+			l.ServerName = m.params[1]
+			l.Version = m.params[2]
+		}
+	case "005":
+		// Drop the target nick and the trailing "are supported by this server"
+		if len(m.params) >= 2 {
+			l.ISupport = append(l.ISupport, m.params[1:len(m.params)-1]...)
+		}
+	case "CAP":
+		if len(m.params) >= 3 && strings.EqualFold(m.params[1], "LS") {
+			for _, capability := range strings.Fields(m.params[len(m.params)-1]) {
+				l.Capabilities = append(l.Capabilities, capability)
+				name := strings.SplitN(capability, "=", 2)[0]
+				if name == "tls" {
+					l.TLSCapability = true
+				}
+			}
+		}
+	case "376", "422", "ERROR", "432", "433", "465":
+		return true
+	}
+	return false
+}
+
+// capLSDone reports whether m is the last line of a CAP LS reply. Multiline
+// replies (CAP LS 302) mark continuation lines with a "*" parameter.
+func capLSDone(m ircMessage) bool {
+	return m.command == "CAP" && len(m.params) >= 3 &&
+		strings.EqualFold(m.params[1], "LS") && m.params[2] != "*"
+}
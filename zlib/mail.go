@@ -14,7 +14,230 @@
 
 package zlib
 
+import (
+	"strings"
+	"time"
+)
+
 // An SMTPHelpEvent represents sending a "HELP" message over SMTP
 type SMTPHelpEvent struct {
 	Response string
 }
+
+// An SMTPCommandEvent records a single SMTP command such as VRFY or EXPN and
+// the server's reply to it
+type SMTPCommandEvent struct {
+	Command  string `json:"command"`
+	Response string `json:"response,omitempty"`
+	Code     int    `json:"code,omitempty"`
+}
+
+// smtpTarpitDelay is the gap between greeting lines, or before the first,
+// that marks a server as likely tarpitting
+const smtpTarpitDelay = time.Second
+
+// An SMTPGreetingLog records how the greeting arrived. LineDelaysMicros holds,
+// for each line, the time since the previous line or, for the first, since
+// the read began. Tarpit is set if any delay reached smtpTarpitDelay, as with
+// greylisting or bot-detecting greeting pauses.
+type SMTPGreetingLog struct {
+	Lines            int     `json:"lines"`
+	LineDelaysMicros []int64 `json:"line_delays_us,omitempty"`
+	TotalMicros      int64   `json:"total_us"`
+	Tarpit           bool    `json:"tarpit,omitempty"`
+}
+
+// addLines records count lines completed delay after the previous one;
+// lines that arrived together after the first have no delay.
+func (g *SMTPGreetingLog) addLines(count int, delay time.Duration) {
+	for i := 0; i < count; i++ {
+		g.Lines++
+		g.LineDelaysMicros = append(g.LineDelaysMicros, int64(delay/time.Microsecond))
+		if delay >= smtpTarpitDelay {
+			g.Tarpit = true
+		}
+		delay = 0
+	}
+}
+
+// smtpStartTLSInjection is the command SMTPStartTLSInjectionTest sends in
+// the same packet as STARTTLS
+const smtpStartTLSInjection = "NOOP\r\n"
+
+// startTLSInjectionWait is how long to wait after the handshake for a reply
+// to the injected command
+const startTLSInjectionWait = 2 * time.Second
+
+// A StartTLSInjectionLog records a STARTTLS command injection test. The
+// server is vulnerable if it answered, over TLS, a command that was sent in
+// plaintext before the handshake.
+type StartTLSInjectionLog struct {
+	Injected   string `json:"injected"`
+	Response   string `json:"response,omitempty"`
+	Vulnerable bool   `json:"vulnerable"`
+}
+
+// ehloKeywords returns the upper-cased extension keywords from a multiline
+// EHLO response. The first line is the server greeting and is skipped.
+func ehloKeywords(ehlo string) []string {
+	var keywords []string
+	lines := strings.Split(ehlo, "\r\n")
+	for i, line := range lines {
+		if i == 0 || len(line) < 4 {
+			continue
+		}
+		fields := strings.Fields(line[4:])
+		if len(fields) == 0 {
+			continue
+		}
+		keywords = append(keywords, strings.ToUpper(fields[0]))
+	}
+	return keywords
+}
+
+// hasEHLOKeyword reports whether the EHLO response advertises keyword.
+func hasEHLOKeyword(ehlo, keyword string) bool {
+	for _, k := range ehloKeywords(ehlo) {
+		if k == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSMTPReplies splits buf into the complete SMTP replies it contains. A
+// reply is complete once a line with a space (or nothing) after the code has
+// been received. Any trailing partial reply is dropped.
+func splitSMTPReplies(buf string) []string {
+	var replies []string
+	start := 0
+	for i := 0; i < len(buf); {
+		end := strings.Index(buf[i:], "\r\n")
+		if end < 0 {
+			break
+		}
+		line := buf[i : i+end]
+		i += end + 2
+		if len(line) == 3 || len(line) > 3 && line[3] == ' ' {
+			replies = append(replies, buf[start:i])
+			start = i
+		}
+	}
+	return replies
+}
+
+// isMaskedSTARTTLS reports whether keyword looks like STARTTLS overwritten by
+// a middlebox, e.g. "XXXXXXXA". Such devices keep the length and replace
+// most of the characters with X.
+func isMaskedSTARTTLS(keyword string) bool {
+	if len(keyword) != len("STARTTLS") || keyword == "STARTTLS" {
+		return false
+	}
+	return strings.Count(keyword, "X") >= len(keyword)/2
+}
+
+// An IMAPIDEvent represents sending an "ID NIL" command (RFC 2971) over IMAP
+type IMAPIDEvent struct {
+	Response string            `json:"response"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// parseIMAPID extracts the field/value pairs from an untagged "* ID" response.
+// NIL values are recorded as empty strings.
+func parseIMAPID(response string) map[string]string {
+	var line string
+	for _, l := range strings.Split(response, "\r\n") {
+		if len(l) >= 4 && strings.EqualFold(l[0:4], "* ID") {
+			line = l[4:]
+			break
+		}
+	}
+	start := strings.Index(line, "(")
+	end := strings.LastIndex(line, ")")
+	if start < 0 || end < start {
+		return nil
+	}
+	var tokens []string
+	s := line[start+1 : end]
+	for len(s) > 0 {
+		switch {
+		case s[0] == ' ':
+			s = s[1:]
+		case s[0] == '"':
+			var tok []byte
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				tok = append(tok, s[i])
+			}
+			tokens = append(tokens, string(tok))
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		default:
+			i := strings.IndexByte(s, ' ')
+			if i < 0 {
+				i = len(s)
+			}
+			if atom := s[:i]; !strings.EqualFold(atom, "NIL") {
+				tokens = append(tokens, atom)
+			} else {
+				tokens = append(tokens, "")
+			}
+			s = s[i:]
+		}
+	}
+	fields := make(map[string]string, len(tokens)/2)
+	for i := 0; i+1 < len(tokens); i += 2 {
+		fields[tokens[i]] = tokens[i+1]
+	}
+	return fields
+}
+
+// An IMAPCapabilityEvent records the capabilities an IMAP server advertises
+// before and after STARTTLS, along with what changed between the two
+type IMAPCapabilityEvent struct {
+	PreTLSResponse  string   `json:"pre_tls_response,omitempty"`
+	PreTLS          []string `json:"pre_tls,omitempty"`
+	PostTLSResponse string   `json:"post_tls_response,omitempty"`
+	PostTLS         []string `json:"post_tls,omitempty"`
+	Added           []string `json:"added,omitempty"`
+	Removed         []string `json:"removed,omitempty"`
+}
+
+// parseIMAPCapabilities returns the upper-cased capabilities listed in the
+// untagged "* CAPABILITY" line of response.
+func parseIMAPCapabilities(response string) []string {
+	const prefix = "* CAPABILITY "
+	for _, l := range strings.Split(response, "\r\n") {
+		if len(l) >= len(prefix) && strings.EqualFold(l[:len(prefix)], prefix) {
+			return strings.Fields(strings.ToUpper(l[len(prefix):]))
+		}
+	}
+	return nil
+}
+
+// diffCapabilities returns the capabilities in post but not pre, and those
+// in pre but not post.
+func diffCapabilities(pre, post []string) (added, removed []string) {
+	inPre := make(map[string]bool, len(pre))
+	for _, c := range pre {
+		inPre[c] = true
+	}
+	inPost := make(map[string]bool, len(post))
+	for _, c := range post {
+		inPost[c] = true
+		if !inPre[c] {
+			added = append(added, c)
+		}
+	}
+	for _, c := range pre {
+		if !inPost[c] {
+			removed = append(removed, c)
+		}
+	}
+	return added, removed
+}
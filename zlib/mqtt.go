@@ -0,0 +1,168 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MQTT protocol levels sent in CONNECT
+const (
+	MQTTProtocolLevel311 = 4
+	MQTTProtocolLevel5   = 5
+)
+
+const (
+	mqttPacketConnect = 0x10
+	mqttPacketConnack = 0x20
+
+	mqttClientID = "zgrab"
+
+	// mqttMaxPacketSize bounds the CONNACK we are willing to read
+	mqttMaxPacketSize = 65536
+)
+
+var mqtt311ReturnCodes = map[byte]string{
+	0x00: "accepted",
+	0x01: "unacceptable_protocol_version",
+	0x02: "identifier_rejected",
+	0x03: "server_unavailable",
+	0x04: "bad_username_or_password",
+	0x05: "not_authorized",
+}
+
+var mqtt5ReasonCodes = map[byte]string{
+	0x00: "success",
+	0x80: "unspecified_error",
+	0x81: "malformed_packet",
+	0x82: "protocol_error",
+	0x83: "implementation_specific_error",
+	0x84: "unsupported_protocol_version",
+	0x85: "client_identifier_not_valid",
+	0x86: "bad_username_or_password",
+	0x87: "not_authorized",
+	0x88: "server_unavailable",
+	0x89: "server_busy",
+	0x8A: "banned",
+	0x8C: "bad_authentication_method",
+	0x90: "topic_name_invalid",
+	0x95: "packet_too_large",
+	0x97: "quota_exceeded",
+	0x99: "payload_format_invalid",
+	0x9A: "retain_not_supported",
+	0x9B: "qos_not_supported",
+	0x9C: "use_another_server",
+	0x9D: "server_moved",
+	0x9F: "connection_rate_exceeded",
+}
+
+// An MQTTLog holds a broker's answer to an anonymous CONNECT
+type MQTTLog struct {
+	// ProtocolLevel is 4 for a 3.1.1 CONNACK and 5 for a 5.0 one
+	ProtocolLevel    int    `json:"protocol_level"`
+	ReturnCode       int    `json:"return_code"`
+	ReturnCodeName   string `json:"return_code_name,omitempty"`
+	SessionPresent   bool   `json:"session_present"`
+	AnonymousAllowed bool   `json:"anonymous_allowed"`
+
+	// Properties holds the raw property block of a 5.0 CONNACK
+	Properties []byte `json:"properties,omitempty"`
+}
+
+// appendMQTTVarInt appends n in the MQTT variable byte integer encoding
+func appendMQTTVarInt(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+// readMQTTVarInt reads an MQTT variable byte integer of at most four bytes
+func readMQTTVarInt(r io.Reader) (int, error) {
+	var b [1]byte
+	n, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		n += int(b[0]&0x7f) * multiplier
+		if b[0]&0x80 == 0 {
+			return n, nil
+		}
+		multiplier *= 128
+	}
+	return 0, errors.New("malformed MQTT variable byte integer")
+}
+
+// makeMQTTConnect builds a clean-session CONNECT without credentials
+func makeMQTTConnect(level byte) []byte {
+	body := []byte{0x00, 0x04, 'M', 'Q', 'T', 'T', level, 0x02, 0x00, 0x3c}
+	if level >= MQTTProtocolLevel5 {
+		body = append(body, 0x00) // no properties
+	}
+	body = append(body, 0x00, byte(len(mqttClientID)))
+	body = append(body, mqttClientID...)
+	packet := appendMQTTVarInt([]byte{mqttPacketConnect}, len(body))
+	return append(packet, body...)
+}
+
+// readMQTTConnack reads a CONNACK from r and records it in log. A 3.1.1
+// CONNACK always has a remaining length of two; anything longer is 5.0.
+func readMQTTConnack(r io.Reader, log *MQTTLog) error {
+	var header [1]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	if header[0]&0xf0 != mqttPacketConnack {
+		return fmt.Errorf("expected MQTT CONNACK, got packet type %d", header[0]>>4)
+	}
+	length, err := readMQTTVarInt(r)
+	if err != nil {
+		return err
+	}
+	if length < 2 || length > mqttMaxPacketSize {
+		return fmt.Errorf("invalid MQTT CONNACK length %d", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	log.SessionPresent = body[0]&0x01 != 0
+	log.ReturnCode = int(body[1])
+	if length == 2 {
+		log.ProtocolLevel = MQTTProtocolLevel311
+		log.ReturnCodeName = mqtt311ReturnCodes[body[1]]
+	} else {
+		log.ProtocolLevel = MQTTProtocolLevel5
+		log.ReturnCodeName = mqtt5ReasonCodes[body[1]]
+		props := bytes.NewReader(body[2:])
+		if n, err := readMQTTVarInt(props); err == nil && n <= props.Len() {
+			start := len(body) - props.Len()
+			log.Properties = body[start : start+n]
+		}
+	}
+	log.AnonymousAllowed = body[1] == 0
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+// maxRetryBackoff caps the wait between retries however many attempts
+// have been made.
+const maxRetryBackoff = time.Minute
+
+// rootCause strips the *url.Error, *net.OpError and *os.SyscallError
+// wrappers the HTTP client and the net package put around a socket error.
+func rootCause(err error) error {
+	for {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		default:
+			return err
+		}
+	}
+}
+
+// isTransientError reports whether a grab that failed with err is worth
+// retrying. Resets, aborted connections and timeouts are often caused by
+// packet loss; refused connections and TLS or certificate failures are
+// treated as permanent.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	switch rootCause(err) {
+	case syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE, io.ErrUnexpectedEOF:
+		return true
+	}
+	return false
+}
+
+// retryBackoff returns how long to wait before the retry following the
+// given attempt, doubling base for each attempt already made, up to
+// maxRetryBackoff.
+func retryBackoff(base time.Duration, attempt uint) time.Duration {
+	backoff := base
+	for i := uint(1); i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
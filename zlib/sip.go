@@ -0,0 +1,142 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// sipMaxMessageSize bounds the headers and body read from a SIP response
+const sipMaxMessageSize = 65536
+
+// A SIPLog holds a SIP server's final response to an OPTIONS request
+type SIPLog struct {
+	StatusLine string   `json:"status_line,omitempty"`
+	StatusCode int      `json:"status_code,omitempty"`
+	Server     string   `json:"server,omitempty"`
+	UserAgent  string   `json:"user_agent,omitempty"`
+	Allow      []string `json:"allow,omitempty"`
+	Body       string   `json:"body,omitempty"`
+}
+
+// sipToken returns a random hex string for SIP tags, branches and Call-IDs
+func sipToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// makeSIPOptions builds an OPTIONS request for host, sent over TCP from
+// local (RFC 3261 section 11.1)
+func makeSIPOptions(host, local string) []byte {
+	return []byte("OPTIONS sip:" + host + " SIP/2.0\r\n" +
+		"Via: SIP/2.0/TCP " + local + ";branch=z9hG4bK" + sipToken() + "\r\n" +
+		"Max-Forwards: 70\r\n" +
+		"From: <sip:zgrab@" + local + ">;tag=" + sipToken() + "\r\n" +
+		"To: <sip:" + host + ">\r\n" +
+		"Call-ID: " + sipToken() + "@" + local + "\r\n" +
+		"CSeq: 1 OPTIONS\r\n" +
+		"Contact: <sip:zgrab@" + local + ">\r\n" +
+		"Accept: application/sdp\r\n" +
+		"Content-Length: 0\r\n" +
+		"\r\n")
+}
+
+// errSIPHeadersTooLong is returned once a response's headers exceed
+// sipMaxMessageSize
+var errSIPHeadersTooLong = errors.New("SIP response headers too long")
+
+// readSIPLine reads one line from r, giving up once more than limit bytes
+// have been read without reaching its end
+func readSIPLine(r *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		frag, err := r.ReadSlice('\n')
+		if len(line)+len(frag) > limit {
+			return "", errSIPHeadersTooLong
+		}
+		line = append(line, frag...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// readSIPResponse reads one SIP response from r into log. Headers run to
+// the first empty line, and the body is exactly Content-Length bytes.
+func readSIPResponse(r *bufio.Reader, log *SIPLog) error {
+	*log = SIPLog{}
+	size := 0
+	contentLength := 0
+	for first := true; ; first = false {
+		line, err := readSIPLine(r, sipMaxMessageSize-size)
+		size += len(line)
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if first {
+			f := strings.SplitN(line, " ", 3)
+			if len(f) < 2 || !strings.HasPrefix(f[0], "SIP/") {
+				return fmt.Errorf("malformed SIP status line %q", line)
+			}
+			log.StatusLine = line
+			if log.StatusCode, err = strconv.Atoi(f[1]); err != nil {
+				return fmt.Errorf("malformed SIP status code %q", f[1])
+			}
+			continue
+		}
+		if line == "" {
+			break
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch strings.ToLower(strings.TrimSpace(line[:i])) {
+		case "content-length", "l":
+			if contentLength, err = strconv.Atoi(value); err != nil || contentLength < 0 {
+				return fmt.Errorf("malformed SIP Content-Length %q", value)
+			}
+		case "server":
+			log.Server = value
+		case "user-agent":
+			log.UserAgent = value
+		case "allow":
+			for _, method := range strings.Split(value, ",") {
+				if method = strings.TrimSpace(method); method != "" {
+					log.Allow = append(log.Allow, method)
+				}
+			}
+		}
+	}
+	if contentLength > sipMaxMessageSize-size {
+		return errors.New("SIP response body too long")
+	}
+	body := make([]byte, contentLength)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	log.Body = string(body)
+	return nil
+}
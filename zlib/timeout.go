@@ -0,0 +1,46 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"fmt"
+	"net"
+)
+
+// A ReadTimeoutError is returned by the banner read methods when the read
+// deadline passed. Read is how many bytes arrived before that; they are kept
+// as the partial banner. EOF and resets are returned unchanged.
+type ReadTimeoutError struct {
+	Read int
+	Err  error
+}
+
+func (e *ReadTimeoutError) Error() string {
+	return fmt.Sprintf("read timed out after %d bytes: %s", e.Read, e.Err)
+}
+
+// Timeout and Temporary make a ReadTimeoutError a net.Error, so callers
+// checking err.(net.Error) still see the timeout.
+func (e *ReadTimeoutError) Timeout() bool   { return true }
+func (e *ReadTimeoutError) Temporary() bool { return true }
+
+// readTimeoutError wraps err in a ReadTimeoutError if it is a deadline
+// timeout, n being the number of bytes read before it.
+func readTimeoutError(n int, err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return &ReadTimeoutError{Read: n, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,220 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bytes"
+
+	jsonKeys "github.com/zmap/zcrypto/json"
+	"github.com/zmap/zcrypto/tls"
+)
+
+// versionIntoleranceProbes are the ClientHello versions offered by
+// CheckVersionIntolerance, ending with one that no TLS version uses.
+var versionIntoleranceProbes = []uint16{
+	tls.VersionTLS10,
+	tls.VersionTLS11,
+	tls.VersionTLS12,
+	0x0304,
+	0x03FF,
+}
+
+// A VersionProbe records the outcome of a handshake offering ClientVersion.
+// Success means the server sent a ServerHello, whatever happened after.
+type VersionProbe struct {
+	ClientVersion tls.TLSVersion `json:"client_version"`
+	Success       bool           `json:"success"`
+	ServerVersion tls.TLSVersion `json:"server_version,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+type VersionIntoleranceLog struct {
+	Probes []VersionProbe `json:"probes"`
+}
+
+// cipherPreferenceProbeSuites are offered by CheckServerCipherPreference when
+// no cipher suites are configured. Nearly every server supports both.
+var cipherPreferenceProbeSuites = []uint16{
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+}
+
+// A CipherPreferenceProbe records the suite the server selected from
+// Offered, if it sent a ServerHello.
+type CipherPreferenceProbe struct {
+	Offered  []tls.CipherSuite `json:"offered"`
+	Selected *tls.CipherSuite  `json:"selected,omitempty"`
+}
+
+// CipherPreferenceLog holds one probe per order offered.
+// ServerHasCipherPreference is only meaningful when Error is empty.
+type CipherPreferenceLog struct {
+	Probes                    []CipherPreferenceProbe `json:"probes"`
+	ServerHasCipherPreference bool                    `json:"server_has_cipher_preference"`
+	Error                     string                  `json:"error,omitempty"`
+}
+
+// A PaddingProbe records whether the server sent a ServerHello in reply to a
+// ClientHello with PaddingLength bytes of padding, 0 meaning none was sent.
+type PaddingProbe struct {
+	Success       bool   `json:"success"`
+	PaddingLength int    `json:"padding_length,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// ClientHelloPaddingLog holds the probes made by CheckClientHelloPadding.
+// Changed is set if only one of them got a ServerHello.
+type ClientHelloPaddingLog struct {
+	Unpadded PaddingProbe `json:"unpadded"`
+	Padded   PaddingProbe `json:"padded"`
+	Changed  bool         `json:"changed"`
+}
+
+// profileVersions are the versions FullTLSProfile tries, newest first. TLS
+// 1.3 is missing because the TLS library cannot negotiate it.
+var profileVersions = []uint16{
+	tls.VersionTLS12,
+	tls.VersionTLS11,
+	tls.VersionTLS10,
+	tls.VersionSSL30,
+}
+
+// profileCipherSuites are offered by FullTLSProfile. Suites the TLS library
+// does not implement are still found, since only the ServerHello is needed.
+var profileCipherSuites = func() []uint16 {
+	var suites []uint16
+	seen := make(map[uint16]bool)
+	for _, list := range [][]uint16{tls.ECDHECiphers, tls.DHECiphers, tls.RSACiphers, tls.ExportCiphers} {
+		for _, suite := range list {
+			if !seen[suite] {
+				seen[suite] = true
+				suites = append(suites, suite)
+			}
+		}
+	}
+	return suites
+}()
+
+// A TLSVersionProfile lists the cipher suites a server accepts with one
+// version, in the order it selected them. ServerCipherPreference is only
+// meaningful when there are at least two suites.
+type TLSVersionProfile struct {
+	Version                tls.TLSVersion    `json:"version"`
+	Supported              bool              `json:"supported"`
+	CipherSuites           []tls.CipherSuite `json:"cipher_suites,omitempty"`
+	ServerCipherPreference bool              `json:"server_cipher_preference,omitempty"`
+}
+
+// A TLSProfile is the result of FullTLSProfile. ForwardSecrecy is set if any
+// accepted suite uses an ephemeral key exchange, and the DH and ECDH
+// parameters are from the first handshakes that used them. Truncated is set
+// if the handshake budget ran out before the profile was complete.
+type TLSProfile struct {
+	Versions        []TLSVersionProfile  `json:"versions"`
+	ForwardSecrecy  bool                 `json:"forward_secrecy"`
+	DHParams        *jsonKeys.DHParams   `json:"dh_params,omitempty"`
+	DHSecurityLevel int                  `json:"dh_security_level,omitempty"`
+	ECDHParams      *jsonKeys.ECDHParams `json:"ecdh_params,omitempty"`
+	Handshakes      int                  `json:"handshakes"`
+	Truncated       bool                 `json:"truncated,omitempty"`
+}
+
+// addKeyExchange keeps the first DH and ECDH parameters seen
+func (p *TLSProfile) addKeyExchange(skx *tls.ServerKeyExchange) {
+	if skx == nil {
+		return
+	}
+	if p.DHParams == nil && skx.DHParams != nil {
+		p.DHParams = skx.DHParams
+		p.DHSecurityLevel = skx.DHParams.SecurityLevel()
+	}
+	if p.ECDHParams == nil && skx.ECDHParams != nil {
+		p.ECDHParams = skx.ECDHParams
+	}
+}
+
+// Estimates made by CheckTicketKeyReuse
+const (
+	TicketKeyShared  = "shared"
+	TicketKeyVaries  = "varies"
+	TicketKeyUnknown = "unknown"
+)
+
+// ticketKeyNameLength is the length of the key_name prefix of the ticket
+// format suggested by RFC 5077, used by OpenSSL and most of its users
+const ticketKeyNameLength = 16
+
+// A TicketKeyReuseLog records the session tickets collected by
+// CheckTicketKeyReuse. KeyNames holds the distinct 16 byte ticket prefixes
+// seen, which name the encrypting key in the common RFC 5077 format.
+// Resumed is set if the first ticket resumed a session on the last
+// connection, after all the others were made.
+type TicketKeyReuseLog struct {
+	Tickets  [][]byte `json:"tickets,omitempty"`
+	KeyNames [][]byte `json:"key_names,omitempty"`
+	Resumed  bool     `json:"resumed"`
+	Estimate string   `json:"estimate"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// estimate judges whether the ticket key is shared across connections.
+// Resuming the first ticket after the other handshakes shows it is. Not all
+// servers use key names, so several of them without resumption only
+// suggest that the key varies by connection or has rotated.
+func (e *TicketKeyReuseLog) estimate() string {
+	switch {
+	case len(e.Tickets) < 2:
+		return TicketKeyUnknown
+	case e.Resumed:
+		return TicketKeyShared
+	case !e.Resumed && len(e.KeyNames) > 1:
+		return TicketKeyVaries
+	}
+	return TicketKeyUnknown
+}
+
+func (e *TicketKeyReuseLog) addTicket(ticket []byte) {
+	e.Tickets = append(e.Tickets, ticket)
+	if len(ticket) < ticketKeyNameLength {
+		return
+	}
+	name := ticket[:ticketKeyNameLength]
+	for _, seen := range e.KeyNames {
+		if bytes.Equal(seen, name) {
+			return
+		}
+	}
+	e.KeyNames = append(e.KeyNames, name)
+}
+
+// firstSessionCache remembers the first session it is given and only hands
+// it back once resume is set, so that every other handshake is a full one.
+type firstSessionCache struct {
+	session *tls.ClientSessionState
+	resume  bool
+}
+
+func (fc *firstSessionCache) Get(string) (*tls.ClientSessionState, bool) {
+	if fc.resume && fc.session != nil {
+		return fc.session, true
+	}
+	return nil, false
+}
+
+func (fc *firstSessionCache) Put(_ string, cs *tls.ClientSessionState) {
+	if fc.session == nil {
+		fc.session = cs
+	}
+}
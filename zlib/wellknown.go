@@ -0,0 +1,134 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/zmap/zgrab/ztools/http"
+)
+
+// DefaultWellKnownPaths are the paths ProbeWellKnownPaths requests unless
+// ConnConfig.WellKnownPaths is set
+var DefaultWellKnownPaths = []string{
+	"/robots.txt",
+	"/sitemap.xml",
+	"/.well-known/security.txt",
+	"/.git/HEAD",
+	"/.env",
+	"/server-status",
+}
+
+// Findings recorded by ProbeWellKnownPaths
+const (
+	WellKnownRobotsTxt           = "robots_txt"
+	WellKnownSitemap             = "sitemap"
+	WellKnownSecurityTxt         = "security_txt"
+	WellKnownExposedGitHead      = "exposed_git_head"
+	WellKnownExposedEnv          = "exposed_env"
+	WellKnownExposedServerStatus = "exposed_server_status"
+)
+
+// A WellKnownFinding notes that the response for Path looks like the real
+// thing rather than an error page served with a 200.
+type WellKnownFinding struct {
+	Path    string `json:"path"`
+	Finding string `json:"finding"`
+}
+
+// A WellKnownLog records the findings from ProbeWellKnownPaths. The
+// responses themselves are in HTTP.Pipelined.
+type WellKnownLog struct {
+	Paths    []string           `json:"paths"`
+	Findings []WellKnownFinding `json:"findings,omitempty"`
+}
+
+var (
+	gitHeadRegex = regexp.MustCompile(`^(ref: refs/\S+|[0-9a-f]{40})\s*$`)
+	envLineRegex = regexp.MustCompile(`^(export\s+)?[A-Za-z_][A-Za-z0-9_]*\s*=`)
+)
+
+// looksLikeGitHead reports whether body is the contents of a git HEAD file:
+// a symbolic ref or a detached commit hash.
+func looksLikeGitHead(body string) bool {
+	return gitHeadRegex.MatchString(body)
+}
+
+// looksLikeDotenv reports whether body is a dotenv file: every line that
+// isn't blank or a comment is a variable assignment, and there is at least
+// one.
+func looksLikeDotenv(body string) bool {
+	assignments := 0
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !envLineRegex.MatchString(line) {
+			return false
+		}
+		assignments++
+	}
+	return assignments > 0
+}
+
+// wellKnownFinding returns the finding for a successful response with body
+// to a request for path, or the empty string if there is nothing notable.
+func wellKnownFinding(path, body string) string {
+	lower := strings.ToLower(body)
+	switch {
+	case strings.HasSuffix(path, "/.git/HEAD"):
+		if looksLikeGitHead(body) {
+			return WellKnownExposedGitHead
+		}
+	case strings.HasSuffix(path, "/.env"):
+		if looksLikeDotenv(body) {
+			return WellKnownExposedEnv
+		}
+	case strings.HasSuffix(path, "/server-status"):
+		if strings.Contains(body, "Server Status for") || strings.Contains(body, "Apache Server Status") {
+			return WellKnownExposedServerStatus
+		}
+	case strings.HasSuffix(path, "/security.txt"):
+		if strings.Contains(lower, "contact:") {
+			return WellKnownSecurityTxt
+		}
+	case strings.HasSuffix(path, "/robots.txt"):
+		if strings.Contains(lower, "user-agent:") {
+			return WellKnownRobotsTxt
+		}
+	case strings.HasSuffix(path, "/sitemap.xml"):
+		if strings.Contains(lower, "<urlset") || strings.Contains(lower, "<sitemapindex") {
+			return WellKnownSitemap
+		}
+	}
+	return ""
+}
+
+// classifyExposure flags Response if it is a successful fetch of /.git/HEAD
+// or /.env whose body looks like the real file rather than an error page.
+func (h *HTTP) classifyExposure() {
+	res := h.Response
+	if res == nil || res.Request == nil || res.Request.URL == nil || res.StatusCode != http.StatusOK {
+		return
+	}
+	switch wellKnownFinding(res.Request.URL.Path, res.BodyText) {
+	case WellKnownExposedGitHead:
+		h.ExposedGitHead = true
+	case WellKnownExposedEnv:
+		h.ExposedEnv = true
+	}
+}
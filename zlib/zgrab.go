@@ -49,24 +49,58 @@ type encodedGrab struct {
 }
 
 type GrabData struct {
-	Banner       string               `json:"banner,omitempty"`
-	Read         string               `json:"read,omitempty"`
-	Write        string               `json:"write,omitempty"`
-	EHLO         string               `json:"ehlo,omitempty"`
-	SMTPHelp     *SMTPHelpEvent       `json:"smtp_help,omitempty"`
-	StartTLS     string               `json:"starttls,omitempty"`
-	TLSHandshake *tls.ServerHandshake `json:"tls,omitempty"`
-	HTTP         *HTTP                `json:"http,omitempty"`
-	Heartbleed   *tls.Heartbleed      `json:"heartbleed,omitempty"`
-	Modbus       *ModbusEvent         `json:"modbus,omitempty"`
-	SMB          *smb.SMBLog          `json:"smb,omitempty"`
-	XSSH         *xssh.HandshakeLog   `json:"xssh,omitempty"`
-	FTP          *ftp.FTPLog          `json:"ftp,omitempty"`
-	BACNet       *bacnet.Log          `json:"bacnet,omitempty"`
-	Fox          *fox.FoxLog          `json:"fox,omitempty"`
-	DNP3         *dnp3.DNP3Log        `json:"dnp3,omitempty"`
-	S7           *siemens.S7Log       `json:"s7,omitempty"`
-	Telnet       *telnet.TelnetLog    `json:"telnet,omitempty"`
+	DNS                *DNSLog                  `json:"dns,omitempty"`
+	Connect            *ConnectLog              `json:"connect,omitempty"`
+	AutoProbe          *AutoProbeLog            `json:"auto_probe,omitempty"`
+	Banner             string                   `json:"banner,omitempty"`
+	SMTPGreeting       *SMTPGreetingLog         `json:"smtp_greeting,omitempty"`
+	RawBanner          []byte                   `json:"raw_banner,omitempty"`
+	BytesRead          int64                    `json:"bytes_read,omitempty"`
+	BytesWritten       int64                    `json:"bytes_written,omitempty"`
+	WriteFragmentation *WriteFragmentationLog   `json:"write_fragmentation,omitempty"`
+	Read               string                   `json:"read,omitempty"`
+	Write              string                   `json:"write,omitempty"`
+	HalfClose          *HalfCloseLog            `json:"half_close,omitempty"`
+	EHLO               string                   `json:"ehlo,omitempty"`
+	HELO               string                   `json:"helo,omitempty"`
+	SMTPHello          string                   `json:"smtp_hello,omitempty"`
+	STARTTLSStripped   bool                     `json:"starttls_stripped,omitempty"`
+	SMTPPipelined      bool                     `json:"smtp_pipelined,omitempty"`
+	SMTPHelp           *SMTPHelpEvent           `json:"smtp_help,omitempty"`
+	SMTPVrfy           *SMTPCommandEvent        `json:"smtp_vrfy,omitempty"`
+	SMTPExpn           *SMTPCommandEvent        `json:"smtp_expn,omitempty"`
+	IMAPID             *IMAPIDEvent             `json:"imap_id,omitempty"`
+	IMAPCapability     *IMAPCapabilityEvent     `json:"imap_capability,omitempty"`
+	StartTLS           string                   `json:"starttls,omitempty"`
+	StartTLSInjection  *StartTLSInjectionLog    `json:"starttls_injection,omitempty"`
+	IsTLS              bool                     `json:"is_tls,omitempty"`
+	TLSHandshake       *tls.ServerHandshake     `json:"tls,omitempty"`
+	NestedTLSHandshake *tls.ServerHandshake     `json:"nested_tls,omitempty"`
+	TLSFlat            *tls.FlatServerHandshake `json:"tls_flat,omitempty"`
+	HTTP               *HTTP                    `json:"http,omitempty"`
+	WellKnown          *WellKnownLog            `json:"well_known,omitempty"`
+	Heartbleed         *tls.Heartbleed          `json:"heartbleed,omitempty"`
+	VersionIntolerance *VersionIntoleranceLog   `json:"version_intolerance,omitempty"`
+	CipherPreference   *CipherPreferenceLog     `json:"cipher_preference,omitempty"`
+	ClientHelloPadding *ClientHelloPaddingLog   `json:"client_hello_padding,omitempty"`
+	TLSProfile         *TLSProfile              `json:"tls_profile,omitempty"`
+	TicketKeyReuse     *TicketKeyReuseLog       `json:"ticket_key_reuse,omitempty"`
+	Modbus             *ModbusEvent             `json:"modbus,omitempty"`
+	SMB                *smb.SMBLog              `json:"smb,omitempty"`
+	XSSH               *xssh.HandshakeLog       `json:"xssh,omitempty"`
+	FTP                *ftp.FTPLog              `json:"ftp,omitempty"`
+	BACNet             *bacnet.Log              `json:"bacnet,omitempty"`
+	Fox                *fox.FoxLog              `json:"fox,omitempty"`
+	DNP3               *dnp3.DNP3Log            `json:"dnp3,omitempty"`
+	S7                 *siemens.S7Log           `json:"s7,omitempty"`
+	Telnet             *telnet.TelnetLog        `json:"telnet,omitempty"`
+	IRC                *IRCLog                  `json:"irc,omitempty"`
+	Whois              *WhoisEvent              `json:"whois,omitempty"`
+	Finger             *FingerEvent             `json:"finger,omitempty"`
+	MQTT               *MQTTLog                 `json:"mqtt,omitempty"`
+	SIP                *SIPLog                  `json:"sip,omitempty"`
+	Gopher             *GopherLog               `json:"gopher,omitempty"`
+	Attempts           uint                     `json:"attempts,omitempty"`
 }
 
 func (g *Grab) MarshalJSON() ([]byte, error) {
@@ -101,6 +135,16 @@ func (g *Grab) UnmarshalJSON(b []byte) error {
 	panic("unimplemented")
 }
 
+// FlattenTLS replaces TLSHandshake with its flat form in TLSFlat, see
+// tls.ServerHandshake.Flatten.
+func (d *GrabData) FlattenTLS() {
+	if d.TLSHandshake == nil {
+		return
+	}
+	d.TLSFlat = d.TLSHandshake.Flatten()
+	d.TLSHandshake = nil
+}
+
 func (g *Grab) status() status {
 	if g.Error != nil {
 		return status_failure
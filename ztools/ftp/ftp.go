@@ -68,3 +68,28 @@ func SetupFTPS(logStruct *FTPLog, connection net.Conn) (bool, error) {
 
 	return false, nil
 }
+
+// SetupDataProtection asks for protected data connections with PBSZ 0 and
+// PROT P (RFC 4217). It must be called over the TLS control channel.
+func SetupDataProtection(logStruct *FTPLog, connection net.Conn) error {
+	buffer := make([]byte, 1024)
+
+	connection.Write([]byte("PBSZ 0\r\n"))
+	respLen, err := util.ReadUntilRegex(connection, buffer, ftpEndRegex)
+	logStruct.PBSZResp = string(buffer[0:respLen])
+	if err != nil {
+		return err
+	}
+
+	connection.Write([]byte("PROT P\r\n"))
+	respLen, err = util.ReadUntilRegex(connection, buffer, ftpEndRegex)
+	logStruct.ProtResp = string(buffer[0:respLen])
+	if err != nil {
+		return err
+	}
+
+	retCode := ftpEndRegex.FindStringSubmatch(logStruct.ProtResp)[1]
+	protected := strings.HasPrefix(retCode, "2")
+	logStruct.DataProtected = &protected
+	return nil
+}
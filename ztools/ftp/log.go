@@ -18,4 +18,12 @@ type FTPLog struct {
 	Banner      string `json:"banner,omitempty"`
 	AuthTLSResp string `json:"auth_tls_resp,omitempty"`
 	AuthSSLResp string `json:"auth_ssl_resp,omitempty"`
+
+	// PBSZResp and ProtResp are the replies to PBSZ 0 and PROT P sent over
+	// the TLS control channel. DataProtected records whether PROT P was
+	// accepted, meaning data transfers would be encrypted too. It is only
+	// set once the server has answered PROT P.
+	PBSZResp      string `json:"pbsz_resp,omitempty"`
+	ProtResp      string `json:"prot_resp,omitempty"`
+	DataProtected *bool  `json:"data_protected,omitempty"`
 }
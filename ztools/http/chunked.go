@@ -38,12 +38,32 @@ type chunkedReader struct {
 	err      error
 	buf      [2]byte
 	checkEnd bool // whether need to check for \r\n chunk footer
+
+	// raw, if non-nil, keeps up to rawMax of the undecoded bytes read
+	raw        *bytes.Buffer
+	rawMax     int
+	rawFlushed bool
+}
+
+// record keeps b in raw, if recording, up to rawMax bytes
+func (cr *chunkedReader) record(b []byte) {
+	if cr.raw == nil {
+		return
+	}
+	if room := cr.rawMax - cr.raw.Len(); room > 0 {
+		if len(b) > room {
+			b = b[:room]
+		}
+		cr.raw.Write(b)
+	}
 }
 
 func (cr *chunkedReader) beginChunk() {
 	// chunk-size CRLF
+	p, err := cr.r.ReadSlice('\n')
+	cr.record(p)
 	var line []byte
-	line, cr.err = readChunkLine(cr.r)
+	line, cr.err = parseChunkLine(p, err)
 	if cr.err != nil {
 		return
 	}
@@ -74,7 +94,10 @@ func (cr *chunkedReader) Read(b []uint8) (n int, err error) {
 				// reading more.
 				break
 			}
-			if _, cr.err = io.ReadFull(cr.r, cr.buf[:2]); cr.err == nil {
+			var n0 int
+			n0, cr.err = io.ReadFull(cr.r, cr.buf[:2])
+			cr.record(cr.buf[:n0])
+			if cr.err == nil {
 				if string(cr.buf[:]) != "\r\n" {
 					cr.err = errors.New("malformed chunked encoding")
 					break
@@ -100,6 +123,7 @@ func (cr *chunkedReader) Read(b []uint8) (n int, err error) {
 		}
 		var n0 int
 		n0, cr.err = cr.r.Read(rbuf)
+		cr.record(rbuf[:n0])
 		n += n0
 		b = b[n0:]
 		cr.n -= uint64(n0)
@@ -109,15 +133,20 @@ func (cr *chunkedReader) Read(b []uint8) (n int, err error) {
 			cr.checkEnd = true
 		}
 	}
+	if cr.err != nil && cr.err != io.EOF && !cr.rawFlushed {
+		// Keep what has already arrived past the point of failure
+		buffered, _ := cr.r.Peek(cr.r.Buffered())
+		cr.record(buffered)
+		cr.rawFlushed = true
+	}
 	return n, cr.err
 }
 
-// Read a line of bytes (up to \n) from b.
-// Give up if the line exceeds maxLineLength.
+// parseChunkLine checks a line of bytes (up to \n) read with ReadSlice,
+// along with the read error. Give up if the line exceeds maxLineLength.
 // The returned bytes are owned by the bufio.Reader
 // so they are only valid until the next bufio read.
-func readChunkLine(b *bufio.Reader) ([]byte, error) {
-	p, err := b.ReadSlice('\n')
+func parseChunkLine(p []byte, err error) ([]byte, error) {
 	if err != nil {
 		// We always know when EOF is coming.
 		// If the caller asked for a line, there should be a line.
@@ -552,6 +552,8 @@ func (c *Client) Do(req *Request) (resp *Response, err error) {
 				Header:   make(Header),
 				Cancel:   ireq.Cancel,
 				ctx:      ireq.ctx,
+				Protocol: ireq.Protocol,
+				Close:    ireq.Close,
 			}
 			if includeBody && ireq.GetBody != nil {
 				req.Body, err = ireq.GetBody()
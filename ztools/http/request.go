@@ -572,7 +572,13 @@ func (req *Request) write(w io.Writer, usingProxy bool, extraHeaders Header, wai
 		w = bw
 	}
 
-	_, err = fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", valueOrDefault(req.Method, "GET"), ruri)
+	// HTTP/1.0 is sent only when named explicitly; anything else,
+	// including a zero Protocol, means HTTP/1.1.
+	proto := "HTTP/1.1"
+	if req.Protocol.Name == "HTTP/1.0" {
+		proto = "HTTP/1.0"
+	}
+	_, err = fmt.Fprintf(w, "%s %s %s\r\n", valueOrDefault(req.Method, "GET"), ruri, proto)
 	if err != nil {
 		return err
 	}
@@ -45,6 +45,20 @@ type Response struct {
 	// Keys in the map are canonicalized (see CanonicalHeaderKey).
 	Header Header `json:"headers,omitempty"`
 
+	// RawHeaders is the status line and header block exactly as received,
+	// including the blank line that ends it. It is only set by
+	// ReadResponseRawHeaders, or a Transport with RawHeaders set.
+	RawHeaders string `json:"raw_headers,omitempty"`
+
+	// OrderedHeaders lists the headers in the order received, with their
+	// original casing. It is only set by a Transport with
+	// PreserveHeaderCase set.
+	OrderedHeaders []HeaderField `json:"ordered_headers,omitempty"`
+
+	// SmugglingIndicators lists the Smuggling constants that apply to the
+	// raw headers. They are only checked for responses read by a Transport.
+	SmugglingIndicators []string `json:"smuggling_indicators,omitempty"`
+
 	// Body represents the response body.
 	//
 	// The http Client and Transport guarantee that Body is always
@@ -59,8 +73,24 @@ type Response struct {
 	// with a "chunked" Transfer-Encoding.
 	Body       io.ReadCloser   `json:"-"`
 	BodyText   string          `json:"body,omitempty"`
+	BodyUTF8   string          `json:"body_utf8,omitempty"`
 	BodySHA256 PageFingerprint `json:"body_sha256,omitempty"`
 
+	// BodyCharsetUnsupported is the declared charset when BodyUTF8 was
+	// requested for a charset other than ISO-8859-1, windows-1252 or
+	// Shift_JIS
+	BodyCharsetUnsupported string `json:"body_charset_unsupported,omitempty"`
+
+	// ChunkedDecodeFailed is set when the chunked body could not be
+	// decoded, in which case BodyText holds the raw, still-chunked bytes
+	// and ChunkedDecodeError the reason.
+	ChunkedDecodeFailed bool   `json:"chunked_decode_failed,omitempty"`
+	ChunkedDecodeError  string `json:"chunked_decode_error,omitempty"`
+
+	// chunked is the body's chunked decoder when it records the raw body,
+	// see Transport.RawChunkedBodySize.
+	chunked *chunkedReader
+
 	// ContentLength records the length of the associated content. The
 	// value -1 indicates that the length is unknown. Unless Request.Method
 	// is "HEAD", values >= 0 indicate that the given number of bytes may
@@ -71,6 +101,14 @@ type Response struct {
 	// nil, means that "identity" encoding is used.
 	TransferEncoding []string `json:"transfer_encoding,omitempty"`
 
+	// ContentEncoding is the Content-Encoding header as received, kept
+	// even when the Transport transparently decompresses the body.
+	ContentEncoding string `json:"content_encoding,omitempty"`
+
+	// SecurityHeaders holds HSTS and other security headers parsed from
+	// Header.
+	SecurityHeaders *SecurityHeaders `json:"security_headers,omitempty"`
+
 	// Close records whether the header directed that the connection be
 	// closed after reading Body. The value is advice for clients: neither
 	// ReadResponse nor Response.Write ever closes a connection.
@@ -153,10 +191,60 @@ func (r *Response) Location() (*url.URL, error) {
 // After that call, clients can inspect resp.Trailer to find key/value
 // pairs included in the response trailer.
 func ReadResponse(r *bufio.Reader, req *Request) (*Response, error) {
+	return readResponse(r, req, responseOptions{})
+}
+
+// ReadResponseRawHeaders is like ReadResponse, but also records the
+// unparsed status line and headers in the returned Response's RawHeaders,
+// preserving the original header casing and order.
+func ReadResponseRawHeaders(r *bufio.Reader, req *Request) (*Response, error) {
+	return readResponse(r, req, responseOptions{rawHeaders: true})
+}
+
+// responseOptions selects what readResponse records beyond ReadResponse
+type responseOptions struct {
+	rawHeaders     bool // the raw header block, in RawHeaders
+	orderedHeaders bool // the headers as received, in OrderedHeaders
+	smuggling      bool // request smuggling preconditions
+	rawChunkedSize int  // bytes of a chunked body to keep undecoded
+}
+
+// A MalformedResponseError is returned by a Transport for a response whose
+// headers were read but show request smuggling preconditions and could not
+// be used. Response holds what was parsed, with SmugglingIndicators set.
+type MalformedResponseError struct {
+	Response *Response
+	Err      error
+}
+
+func (e *MalformedResponseError) Error() string {
+	return e.Err.Error()
+}
+
+// readResponse is ReadResponse, recording what opts asks for.
+func readResponse(r *bufio.Reader, req *Request, opts responseOptions) (*Response, error) {
+	keepRaw, keepOrder := opts.rawHeaders, opts.orderedHeaders
 	tp := textproto.NewReader(r)
 	resp := &Response{
 		Request: req,
 	}
+	if keepRaw || keepOrder || opts.smuggling {
+		raw, err := readRawHeaderBlock(r)
+		if keepRaw {
+			resp.RawHeaders = string(raw)
+		}
+		if keepOrder {
+			resp.OrderedHeaders = ParseHeaderFields(raw)
+		}
+		if opts.smuggling {
+			resp.SmugglingIndicators = SmugglingIndicators(raw)
+		}
+		if err != nil && err != io.EOF {
+			return resp, err
+		}
+		// A truncated block is left for the parser below to reject
+		tp = textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+	}
 
 	// Parse the first line of the response.
 	line, err := tp.ReadLine()
@@ -200,15 +288,124 @@ func ReadResponse(r *bufio.Reader, req *Request) (*Response, error) {
 	resp.Header = Header(mimeHeader)
 
 	fixPragmaCacheControl(resp.Header)
+	resp.ContentEncoding = resp.Header.get("Content-Encoding")
+	resp.SecurityHeaders = ParseSecurityHeaders(resp.Header)
 
 	err = readTransfer(resp, r)
 	if err != nil {
+		if len(resp.SmugglingIndicators) > 0 {
+			err = &MalformedResponseError{Response: resp, Err: err}
+		}
 		return resp, err
 	}
+	if cr, ok := bodySource(resp.Body).(*chunkedReader); ok && opts.rawChunkedSize > 0 {
+		cr.raw = new(bytes.Buffer)
+		cr.rawMax = opts.rawChunkedSize
+		resp.chunked = cr
+	}
 
 	return resp, nil
 }
 
+// RawChunkedBody returns the undecoded bytes of a chunked body read so far,
+// or nil if they were not recorded, see Transport.RawChunkedBodySize.
+func (r *Response) RawChunkedBody() []byte {
+	if r.chunked == nil {
+		return nil
+	}
+	return r.chunked.raw.Bytes()
+}
+
+// readRawHeaderBlock reads lines from r up to and including the first empty
+// one, returning them unmodified.
+func readRawHeaderBlock(r *bufio.Reader) ([]byte, error) {
+	var raw []byte
+	for {
+		start := len(raw)
+		line, err := r.ReadSlice('\n')
+		raw = append(raw, line...)
+		for err == bufio.ErrBufferFull {
+			line, err = r.ReadSlice('\n')
+			raw = append(raw, line...)
+		}
+		if err != nil {
+			return raw, err
+		}
+		if line := raw[start:]; len(line) == 1 || (len(line) == 2 && line[0] == '\r') {
+			return raw, nil
+		}
+	}
+}
+
+// Request smuggling preconditions found in a response's raw headers
+const (
+	// Both Content-Length and Transfer-Encoding are present
+	SmugglingContentLengthAndTransferEncoding = "content_length_and_transfer_encoding"
+	// Content-Length appears more than once with different values
+	SmugglingConflictingContentLength = "conflicting_content_length"
+)
+
+// SmugglingIndicators inspects a raw status line and header block for
+// framing that front ends and origins may disagree on.
+func SmugglingIndicators(raw []byte) []string {
+	var lengths []string
+	transferEncoding := false
+	for _, f := range ParseHeaderFields(raw) {
+		switch strings.ToLower(f.Name) {
+		case "content-length":
+			lengths = append(lengths, f.Value)
+		case "transfer-encoding":
+			transferEncoding = true
+		}
+	}
+	var indicators []string
+	if len(lengths) > 0 && transferEncoding {
+		indicators = append(indicators, SmugglingContentLengthAndTransferEncoding)
+	}
+	for i := 1; i < len(lengths); i++ {
+		if lengths[i] != lengths[0] {
+			indicators = append(indicators, SmugglingConflictingContentLength)
+			break
+		}
+	}
+	return indicators
+}
+
+// HeaderField is a single header line as it appeared on the wire.
+type HeaderField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ParseHeaderFields splits a raw status line and header block into its
+// header fields, keeping their order and the casing of their names.
+// Folded continuation lines are joined to the preceding value and lines
+// without a colon are skipped.
+func ParseHeaderFields(raw []byte) []HeaderField {
+	var fields []HeaderField
+	lines := strings.Split(string(raw), "\n")
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			last := &fields[len(fields)-1]
+			last.Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		fields = append(fields, HeaderField{
+			Name:  line[:i],
+			Value: strings.TrimSpace(line[i+1:]),
+		})
+	}
+	return fields
+}
+
 // RFC 2616: Should treat
 //	Pragma: no-cache
 // like
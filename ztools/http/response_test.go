@@ -834,6 +834,49 @@ func TestResponseContentLengthShortBody(t *testing.T) {
 	}
 }
 
+func TestReadResponseRawHeaders(t *testing.T) {
+	const head = "HTTP/1.1 200 OK\r\n" +
+		"X-POWERED-BY: PHP\r\n" +
+		"content-length: 5\r\n" +
+		"\r\n"
+	br := bufio.NewReader(strings.NewReader(head + "hello"))
+	res, err := ReadResponseRawHeaders(br, &Request{Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RawHeaders != head {
+		t.Errorf("RawHeaders = %q; want %q", res.RawHeaders, head)
+	}
+	if got := res.Header.Get("X-Powered-By"); got != "PHP" {
+		t.Errorf("X-Powered-By = %q; want %q", got, "PHP")
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil || string(body) != "hello" {
+		t.Errorf("Body = %q, %v; want %q", body, err, "hello")
+	}
+}
+
+func TestParseHeaderFields(t *testing.T) {
+	raw := "HTTP/1.1 200 OK\r\n" +
+		"X-POWERED-BY: PHP\r\n" +
+		"server:nginx\r\n" +
+		"X-Folded: a\r\n" +
+		"\tb\r\n" +
+		"garbage\r\n" +
+		"Server: second\r\n" +
+		"\r\n" +
+		"Not-A-Header: body\r\n"
+	want := []HeaderField{
+		{"X-POWERED-BY", "PHP"},
+		{"server", "nginx"},
+		{"X-Folded", "a b"},
+		{"Server", "second"},
+	}
+	if got := ParseHeaderFields([]byte(raw)); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseHeaderFields:\n got: %q\nwant: %q", got, want)
+	}
+}
+
 // Test various ReadResponse error cases. (also tests success cases, but mostly
 // it's about errors).  This does not test anything involving the bodies. Only
 // the return value from ReadResponse itself.
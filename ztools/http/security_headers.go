@@ -0,0 +1,67 @@
+package http
+
+import (
+	"strconv"
+	"strings"
+)
+
+// StrictTransportSecurity is a parsed Strict-Transport-Security header
+// (RFC 6797). Valid is false when max-age is missing or malformed, in
+// which case browsers ignore the header.
+type StrictTransportSecurity struct {
+	MaxAge            int64 `json:"max_age"`
+	IncludeSubDomains bool  `json:"include_subdomains,omitempty"`
+	Preload           bool  `json:"preload,omitempty"`
+	Valid             bool  `json:"valid"`
+}
+
+// SecurityHeaders holds commonly queried security headers in structured
+// form. The raw values remain in the response headers.
+type SecurityHeaders struct {
+	StrictTransportSecurity *StrictTransportSecurity `json:"strict_transport_security,omitempty"`
+	ContentSecurityPolicy   string                   `json:"content_security_policy,omitempty"`
+	XFrameOptions           string                   `json:"x_frame_options,omitempty"`
+	XContentTypeOptions     string                   `json:"x_content_type_options,omitempty"`
+}
+
+// ParseSecurityHeaders extracts the security headers from h, returning nil
+// if none are present. Only the first Strict-Transport-Security header is
+// used, as RFC 6797 requires.
+func ParseSecurityHeaders(h Header) *SecurityHeaders {
+	sh := &SecurityHeaders{
+		ContentSecurityPolicy: h.get("Content-Security-Policy"),
+		XFrameOptions:         h.get("X-Frame-Options"),
+		XContentTypeOptions:   h.get("X-Content-Type-Options"),
+	}
+	if sts := h.get("Strict-Transport-Security"); sts != "" {
+		sh.StrictTransportSecurity = parseStrictTransportSecurity(sts)
+	}
+	if *sh == (SecurityHeaders{}) {
+		return nil
+	}
+	return sh
+}
+
+func parseStrictTransportSecurity(value string) *StrictTransportSecurity {
+	sts := new(StrictTransportSecurity)
+	for _, directive := range strings.Split(value, ";") {
+		name, arg := directive, ""
+		if i := strings.Index(directive, "="); i >= 0 {
+			name, arg = directive[:i], directive[i+1:]
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		arg = strings.Trim(strings.TrimSpace(arg), `"`)
+		switch name {
+		case "max-age":
+			if maxAge, err := strconv.ParseInt(arg, 10, 64); err == nil && maxAge >= 0 {
+				sts.MaxAge = maxAge
+				sts.Valid = true
+			}
+		case "includesubdomains":
+			sts.IncludeSubDomains = true
+		case "preload":
+			sts.Preload = true
+		}
+	}
+	return sts
+}
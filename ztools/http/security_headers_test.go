@@ -0,0 +1,52 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+)
+
+var securityHeadersTests = []struct {
+	h        Header
+	expected *SecurityHeaders
+}{
+	{Header{"Content-Type": {"text/html"}}, nil},
+	{
+		Header{"Strict-Transport-Security": {"max-age=31536000; includeSubDomains; preload"}},
+		&SecurityHeaders{
+			StrictTransportSecurity: &StrictTransportSecurity{MaxAge: 31536000, IncludeSubDomains: true, Preload: true, Valid: true},
+		},
+	},
+	{
+		Header{"Strict-Transport-Security": {`Max-Age="600"`, "max-age=1"}},
+		&SecurityHeaders{
+			StrictTransportSecurity: &StrictTransportSecurity{MaxAge: 600, Valid: true},
+		},
+	},
+	{
+		Header{"Strict-Transport-Security": {"includeSubDomains; max-age=soon"}},
+		&SecurityHeaders{
+			StrictTransportSecurity: &StrictTransportSecurity{IncludeSubDomains: true},
+		},
+	},
+	{
+		Header{
+			"Content-Security-Policy": {"default-src 'self'"},
+			"X-Frame-Options":         {"DENY"},
+			"X-Content-Type-Options":  {"nosniff"},
+		},
+		&SecurityHeaders{
+			ContentSecurityPolicy: "default-src 'self'",
+			XFrameOptions:         "DENY",
+			XContentTypeOptions:   "nosniff",
+		},
+	},
+}
+
+func TestParseSecurityHeaders(t *testing.T) {
+	for i, test := range securityHeadersTests {
+		got := ParseSecurityHeaders(test.h)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("#%d:\n got: %+v\nwant: %+v", i, got, test.expected)
+		}
+	}
+}
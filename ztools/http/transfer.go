@@ -723,6 +723,14 @@ func fixTrailer(header Header, te []string) (Header, error) {
 // body turns a Reader into a ReadCloser.
 // Close ensures that the body has been fully read
 // and then reads the trailer if necessary.
+// bodySource returns the reader under a Body made by readTransfer, or nil
+func bodySource(rc io.ReadCloser) io.Reader {
+	if b, ok := rc.(*body); ok {
+		return b.src
+	}
+	return nil
+}
+
 type body struct {
 	src          io.Reader
 	hdr          interface{}   // non-nil (Response or Request) value means read trailer
@@ -191,6 +191,18 @@ type Transport struct {
 	// Zero means to use a default limit.
 	MaxResponseHeaderBytes int64
 
+	// RawHeaders, if true, records each response's status line and
+	// headers as received in Response.RawHeaders.
+	RawHeaders bool
+
+	// PreserveHeaderCase, if true, records each response's headers in
+	// the order and casing received in Response.OrderedHeaders.
+	PreserveHeaderCase bool
+
+	// RawChunkedBodySize, if positive, keeps up to this many undecoded
+	// bytes of each chunked response body, see Response.RawChunkedBody.
+	RawChunkedBodySize int
+
 	// nextProtoOnce guards initialization of TLSNextProto and
 	// h2transport (via onceSetNextProtoDefaults)
 	nextProtoOnce sync.Once
@@ -312,6 +324,16 @@ func (tr *transportRequest) extraHeaders() Header {
 	return tr.extra
 }
 
+// responseOptions returns what to record when reading responses
+func (t *Transport) responseOptions() responseOptions {
+	return responseOptions{
+		rawHeaders:     t.RawHeaders,
+		orderedHeaders: t.PreserveHeaderCase,
+		smuggling:      true,
+		rawChunkedSize: t.RawChunkedBodySize,
+	}
+}
+
 // RoundTrip implements the RoundTripper interface.
 //
 // For higher-level HTTP client support (such as handling of cookies
@@ -1648,7 +1670,7 @@ func (pc *persistConn) readResponse(rc requestAndChan, trace *httptrace.ClientTr
 			trace.GotFirstResponseByte()
 		}
 	}
-	resp, err = ReadResponse(pc.br, rc.req)
+	resp, err = readResponse(pc.br, rc.req, pc.t.responseOptions())
 	if err != nil {
 		return
 	}
@@ -1664,7 +1686,7 @@ func (pc *persistConn) readResponse(rc requestAndChan, trace *httptrace.ClientTr
 	}
 	if resp.StatusCode == 100 {
 		pc.readLimit = pc.maxHeaderResponseSize() // reset the limit
-		resp, err = ReadResponse(pc.br, rc.req)
+		resp, err = readResponse(pc.br, rc.req, pc.t.responseOptions())
 		if err != nil {
 			return
 		}
@@ -43,7 +43,7 @@ var allSupportedCiphers = []string{
 // defaultKexAlgos specifies the default key-exchange algorithms in
 // preference order.
 var defaultKexAlgos = []string{
-	kexAlgoCurve25519SHA256,
+	kexAlgoCurve25519SHA256RFC, kexAlgoCurve25519SHA256,
 	// P384 and P521 are not constant-time yet, but since we don't
 	// reuse ephemeral keys, using them for ECDH should be OK.
 	kexAlgoECDH256, kexAlgoECDH384, kexAlgoECDH521,
@@ -52,13 +52,15 @@ var defaultKexAlgos = []string{
 
 // allSupportedKexAlgos specifies all key-exchange algorithms supported
 var allSupportedKexAlgos = []string{
-	kexAlgoCurve25519SHA256,
+	kexAlgoCurve25519SHA256RFC, kexAlgoCurve25519SHA256,
 	// P384 and P521 are not constant-time yet, but since we don't
 	// reuse ephemeral keys, using them for ECDH should be OK.
 	kexAlgoECDH256, kexAlgoECDH384, kexAlgoECDH521,
 	kexAlgoDH14SHA1, kexAlgoDH1SHA1,
 	// Not enabled by default:
 	kexAlgoDHGEXSHA1, kexAlgoDHGEXSHA256,
+	// Offered for probing only, see kexAlgoSNTRUP761X25519SHA512:
+	kexAlgoSNTRUP761X25519SHA512,
 }
 
 // supportedHostKeyAlgos specifies the supported host-key algorithms (i.e. methods
@@ -68,6 +70,7 @@ var supportedHostKeyAlgos = []string{
 	CertAlgoECDSA384v01, CertAlgoECDSA521v01, CertAlgoED25519v01,
 
 	KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521,
+	SigAlgoRSASHA2512, SigAlgoRSASHA2256,
 	KeyAlgoRSA, KeyAlgoDSA,
 
 	KeyAlgoED25519,
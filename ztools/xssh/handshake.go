@@ -402,6 +402,9 @@ func (t *handshakeTransport) enterKeyExchangeLocked(otherInitPacket []byte) erro
 
 	kex, ok := kexAlgoMap[algs.kex]
 	if !ok {
+		if algs.kex == kexAlgoSNTRUP761X25519SHA512 {
+			return fmt.Errorf("ssh: server selected %v, which is offered for probing only", algs.kex)
+		}
 		return fmt.Errorf("ssh: unexpected key exchange algorithm %v", algs.kex)
 	}
 
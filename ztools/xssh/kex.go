@@ -29,6 +29,16 @@ const (
 	kexAlgoECDH384          = "ecdh-sha2-nistp384"
 	kexAlgoECDH521          = "ecdh-sha2-nistp521"
 	kexAlgoCurve25519SHA256 = "curve25519-sha256@libssh.org"
+
+	// kexAlgoCurve25519SHA256RFC is the RFC 8731 name for the libssh
+	// algorithm above.
+	kexAlgoCurve25519SHA256RFC = "curve25519-sha256"
+
+	// kexAlgoSNTRUP761X25519SHA512 is the OpenSSH hybrid post-quantum key
+	// exchange. It is not implemented and can only be offered to see whether
+	// a server selects it; the handshake then fails after the algorithm
+	// selection has been logged.
+	kexAlgoSNTRUP761X25519SHA512 = "sntrup761x25519-sha512@openssh.com"
 )
 
 // kexResult captures the outcome of a key exchange.
@@ -596,6 +606,7 @@ func init() {
 	kexAlgoMap[kexAlgoECDH384] = &ecdh{curve: elliptic.P384()}
 	kexAlgoMap[kexAlgoECDH256] = &ecdh{curve: elliptic.P256()}
 	kexAlgoMap[kexAlgoCurve25519SHA256] = &curve25519sha256{}
+	kexAlgoMap[kexAlgoCurve25519SHA256RFC] = &curve25519sha256{}
 	kexAlgoMap[kexAlgoDHGEXSHA1] = &dhGEXSHA{hashFunc: crypto.SHA1}
 	kexAlgoMap[kexAlgoDHGEXSHA256] = &dhGEXSHA{hashFunc: crypto.SHA256}
 }
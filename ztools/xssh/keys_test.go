@@ -6,6 +6,7 @@ package xssh
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -112,6 +113,31 @@ func TestKeySignVerify(t *testing.T) {
 	}
 }
 
+func TestRSASHA2Verify(t *testing.T) {
+	priv := testPrivateKeys["rsa"].(*rsa.PrivateKey)
+	pub := testSigners["rsa"].PublicKey()
+	data := []byte("sign me")
+	for format, hash := range map[string]crypto.Hash{
+		SigAlgoRSASHA2256: crypto.SHA256,
+		SigAlgoRSASHA2512: crypto.SHA512,
+	} {
+		h := hash.New()
+		h.Write(data)
+		blob, err := rsa.SignPKCS1v15(rand.Reader, priv, hash, h.Sum(nil))
+		if err != nil {
+			t.Fatalf("SignPKCS1v15(%s): %v", format, err)
+		}
+		sig := &Signature{Format: format, Blob: blob}
+		if err := pub.Verify(data, sig); err != nil {
+			t.Errorf("publicKey.Verify(%s): %v", format, err)
+		}
+		sig.Blob[5]++
+		if err := pub.Verify(data, sig); err == nil {
+			t.Errorf("publicKey.Verify(%s) on broken sig did not fail", format)
+		}
+	}
+}
+
 func TestParseRSAPrivateKey(t *testing.T) {
 	key := testPrivateKeys["rsa"]
 